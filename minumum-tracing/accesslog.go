@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// accessLog emits one log line per request with an httpRequest field in the
+// shape Cloud Logging recognizes, so entries render as request logs, and
+// counts the request in served. The entry is correlated with the server span
+// and also records its trace flags and, when the request continued a trace,
+// whether the parent came from upstream. Only a sampleRate fraction of the
+// successful requests is logged, while errors always are.
+// https://cloud.google.com/logging/docs/reference/v2/rest/v2/LogEntry#HttpRequest
+func accessLog(served *atomic.Int64, sampleRate float64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, slot := withServerSpanSlot(r.Context())
+			rec := newStatusRecorder(w)
+			start := time.Now()
+			next.ServeHTTP(rec, r.WithContext(ctx))
+			latency := time.Since(start)
+			served.Add(1)
+
+			if rec.status < http.StatusBadRequest && !sampleAccessLog(ctx, slot.span, sampleRate) {
+				return
+			}
+			attrs := []any{
+				slog.Group("httpRequest",
+					slog.String("requestMethod", r.Method),
+					slog.String("requestUrl", r.URL.String()),
+					slog.Int("status", rec.status),
+					slog.String("responseSize", strconv.FormatInt(rec.written, 10)),
+					slog.String("latency", fmt.Sprintf("%.3fs", latency.Seconds())),
+					slog.String("userAgent", r.UserAgent()),
+					slog.String("remoteIp", clientIP(r)),
+					slog.String("protocol", r.Proto),
+				),
+			}
+			if slot.span.IsValid() {
+				// The span is not in ctx, so put it there for the log handler
+				// to add the trace and span IDs.
+				ctx = trace.ContextWithSpanContext(ctx, slot.span)
+				attrs = append(attrs, slog.String("trace_flags", slot.span.TraceFlags().String()))
+				if slot.parent.IsValid() {
+					attrs = append(attrs, slog.Bool("parent_remote", slot.parent.IsRemote()))
+				}
+			}
+			slog.InfoContext(ctx, "request served", attrs...)
+		})
+	}
+}
+
+// sampleAccessLog reports whether the access log of a request is kept at
+// rate. The decision is derived from the trace ID the same way the trace ID
+// ratio sampler does, or from the request ID on untraced routes, so that it
+// is consistent across the logs of a trace and matches the sampled traces
+// when both rates are equal.
+func sampleAccessLog(ctx context.Context, sc trace.SpanContext, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	var x uint64
+	if sc.IsValid() {
+		id := sc.TraceID()
+		x = binary.BigEndian.Uint64(id[8:16]) >> 1
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(requestIDFromContext(ctx)))
+		x = h.Sum64() >> 1
+	}
+	return x < uint64(rate*(1<<63))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+	s, exp := newTestServer(t, testConfig(t))
+	r := httptest.NewRequest("GET", "/echo/hi?x=1", nil)
+	r.Header.Set("User-Agent", "test-agent")
+	r.RemoteAddr = "203.0.113.7:1234"
+
+	w := serve(s, r)
+
+	entry := findLog(t, logs(), "request served")
+	req, ok := entry["httpRequest"].(map[string]any)
+	if !ok {
+		t.Fatalf("httpRequest = %v, want an object", entry["httpRequest"])
+	}
+	want := map[string]any{
+		"requestMethod": "GET",
+		"requestUrl":    "/echo/hi?x=1",
+		"status":        float64(200),
+		"responseSize":  strconv.Itoa(w.Body.Len()),
+		"userAgent":     "test-agent",
+		"remoteIp":      "203.0.113.7",
+		"protocol":      "HTTP/1.1",
+	}
+	for k, v := range want {
+		if req[k] != v {
+			t.Errorf("httpRequest.%s = %v, want %v", k, req[k], v)
+		}
+	}
+	if latency, _ := req["latency"].(string); !regexp.MustCompile(`^\d+\.\d{3}s$`).MatchString(latency) {
+		t.Errorf("httpRequest.latency = %q, want seconds such as 0.100s", latency)
+	}
+	span := findSpan(t, exp, "echo-handler")
+	if entry["trace_id"] != span.SpanContext.TraceID().String() {
+		t.Errorf("trace_id = %v, want the server span", entry["trace_id"])
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	t.Setenv("ACCESS_LOG_SAMPLE_RATE", "0.1")
+	logs := captureLogs(t)
+	s, _ := newTestServer(t, testConfig(t))
+
+	const n = 200
+	for range n {
+		serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+		serve(s, httptest.NewRequest("GET", "/echo/a/b", nil))
+	}
+
+	statuses := map[float64]int{}
+	for _, entry := range logs() {
+		if entry["msg"] == "request served" {
+			statuses[entry["httpRequest"].(map[string]any)["status"].(float64)]++
+		}
+	}
+	if got := statuses[http.StatusBadRequest]; got != n {
+		t.Errorf("logged %d of %d errors, want all of them", got, n)
+	}
+	if got := statuses[http.StatusOK]; got == 0 || got == n {
+		t.Errorf("logged %d of %d successes, want a fraction at rate 0.1", got, n)
+	}
+}
+
+func TestSampleAccessLogMatchesTraceSampling(t *testing.T) {
+	const rate = 0.25
+	sampler := sdktrace.TraceIDRatioBased(rate)
+	for i := range 100 {
+		var id trace.TraceID
+		binary.BigEndian.PutUint64(id[8:], uint64(i)*0x9e3779b97f4a7c15)
+		id[0] = 1
+		sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: id, SpanID: trace.SpanID{1}})
+
+		got := sampleAccessLog(context.Background(), sc, rate)
+
+		want := sampler.ShouldSample(sdktrace.SamplingParameters{TraceID: id}).Decision == sdktrace.RecordAndSample
+		if got != want {
+			t.Errorf("sampleAccessLog(%s) = %t, want %t like the trace ID ratio sampler", id, got, want)
+		}
+	}
+}
+
+func TestAccessLogSpanContext(t *testing.T) {
+	logs := captureLogs(t)
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve(s, r)
+
+	var entries []map[string]any
+	for _, entry := range logs() {
+		if entry["msg"] == "request served" {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) != 2 {
+		t.Fatalf("logged %d requests, want 2", len(entries))
+	}
+	if _, ok := entries[0]["parent_remote"]; ok {
+		t.Errorf("parent_remote = %v without an upstream parent, want it omitted", entries[0]["parent_remote"])
+	}
+
+	entry := entries[1]
+	var span tracetest.SpanStub
+	for _, stub := range exp.GetSpans() {
+		if stub.Name == "echo-handler" && stub.Parent.IsRemote() {
+			span = stub
+		}
+	}
+	want := map[string]any{
+		"trace_id":      "4bf92f3577b34da6a3ce929d0e0e4736",
+		"span_id":       span.SpanContext.SpanID().String(),
+		"trace_flags":   "01",
+		"parent_remote": true,
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+// requireAdminToken rejects requests whose X-Admin-Token header does not
+// match token with 401.
+func requireAdminToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := r.Header.Get(adminTokenHeader)
+		if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized), traceIDFromContext(r.Context()))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleAdminShutdown starts the same graceful shutdown as SIGTERM. The
+// shutdown runs asynchronously, so the response only acknowledges it.
+func (s *server) handleAdminShutdown(w http.ResponseWriter, r *http.Request) {
+	slog.WarnContext(r.Context(), "shutdown requested via admin endpoint")
+	s.requestShutdown()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleAdminLogLevel changes the minimum log level at runtime, e.g.
+// POST /admin/log-level?level=debug for a short investigation.
+func (s *server) handleAdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(r.URL.Query().Get("level"))); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "level must be debug, info, warn or error", traceIDFromContext(r.Context()))
+		return
+	}
+	previous := s.logLevel.Level()
+	s.logLevel.Set(level)
+	slog.WarnContext(r.Context(), "log level changed via admin endpoint", "from", previous.String(), "to", level.String())
+	w.WriteHeader(http.StatusNoContent)
+}
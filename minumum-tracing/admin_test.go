@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminShutdown(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s, _ := newTestServer(t, testConfig(t))
+	requested := 0
+	s.requestShutdown = func() { requested++ }
+
+	tests := []struct {
+		token      string
+		wantStatus int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{"secret", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/admin/shutdown", nil)
+		if tt.token != "" {
+			r.Header.Set(adminTokenHeader, tt.token)
+		}
+		if w := serve(s, r); w.Code != tt.wantStatus {
+			t.Errorf("token %q: status = %d, want %d", tt.token, w.Code, tt.wantStatus)
+		}
+	}
+	if requested != 1 {
+		t.Errorf("shutdown requested %d times, want 1", requested)
+	}
+}
+
+func TestAdminShutdownDisabled(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	if w := serve(s, httptest.NewRequest("POST", "/admin/shutdown", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// audit emits an audit log entry for every request to a sensitive endpoint,
+// such as the admin and debug ones, recording who called it and with what
+// outcome. Entries carry audit=true so that they can be filtered apart from
+// the access log.
+func audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		outcome := "success"
+		switch {
+		case rec.status == http.StatusUnauthorized || rec.status == http.StatusForbidden:
+			outcome = "denied"
+		case rec.status >= http.StatusBadRequest:
+			outcome = "failed"
+		}
+		ctx := r.Context()
+		slog.InfoContext(ctx, "audit",
+			"audit", true,
+			"endpoint", r.Method+" "+routeFromContext(ctx),
+			"client_ip", clientIP(r),
+			"status", rec.status,
+			"outcome", outcome,
+		)
+	})
+}
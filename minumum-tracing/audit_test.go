@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuditAdminShutdown(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "secret")
+	s, _ := newTestServer(t, testConfig(t))
+	s.requestShutdown = func() {}
+	tests := []struct {
+		token       string
+		wantStatus  float64
+		wantOutcome string
+	}{
+		{"wrong", http.StatusUnauthorized, "denied"},
+		{"secret", http.StatusAccepted, "success"},
+	}
+	for _, tt := range tests {
+		logs := captureLogs(t)
+		r := httptest.NewRequest("POST", "/admin/shutdown", nil)
+		r.Header.Set(adminTokenHeader, tt.token)
+		// The leftmost entry is whatever the caller claimed; the trusted
+		// front end appended the address it actually saw.
+		r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7")
+
+		serve(s, r)
+
+		entry := findLog(t, logs(), "audit")
+		if entry["audit"] != true || entry["endpoint"] != "POST /admin/shutdown" || entry["client_ip"] != "203.0.113.7" {
+			t.Errorf("token %q: audit entry = %v, want POST /admin/shutdown from the trusted address 203.0.113.7", tt.token, entry)
+		}
+		if entry["status"] != tt.wantStatus || entry["outcome"] != tt.wantOutcome {
+			t.Errorf("token %q: status, outcome = %v, %v, want %v, %s", tt.token, entry["status"], entry["outcome"], tt.wantStatus, tt.wantOutcome)
+		}
+	}
+}
+
+func TestAuditSkipsRegularRequests(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	logs := captureLogs(t)
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	for _, entry := range logs() {
+		if entry["msg"] == "audit" {
+			t.Errorf("audit entry %v for a regular request, want none", entry)
+		}
+	}
+}
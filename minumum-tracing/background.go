@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// backgroundTaskDelay is how long the background task started by
+// handleEchoBackground waits before doing its work.
+const backgroundTaskDelay = 100 * time.Millisecond
+
+// BackgroundResponse is the response of the background echo.
+type BackgroundResponse struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// handleEchoBackground accepts the message and logs it from a goroutine after
+// the response has been sent.
+//
+// The request context is cancelled as soon as the handler returns, so the task
+// cannot use it as is. It runs on a context detached from the request's
+// cancellation, which keeps its values such as the request ID, and in a new
+// trace linked to the request span rather than as a child of it: the request
+// trace stays short, and the task's trace can still be found from it.
+func (s *server) handleEchoBackground(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	message := r.PathValue("message")
+	if !s.checkMessageLength(w, r, message) {
+		return
+	}
+
+	taskCtx, span := s.tracer.Start(context.WithoutCancel(ctx), "echo-background-task",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx, attribute.String("link.type", "spawned_by"))),
+	)
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		defer span.End()
+
+		time.Sleep(backgroundTaskDelay)
+		slog.InfoContext(taskCtx, "background echo", "message", message)
+	}()
+
+	trace.SpanFromContext(ctx).AddEvent("background_task_started", trace.WithAttributes(
+		attribute.String("task.trace_id", span.SpanContext().TraceID().String()),
+	))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	s.writeJSON(ctx, w, s.withResponseField(BackgroundResponse{Message: message, Status: "accepted"}))
+}
+
+// waitBackground waits for the background tasks to finish, so that their
+// spans end before the tracer provider is shut down.
+func (s *server) waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEchoBackgroundLinksRequest(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("POST", "/echo/hi/background", nil))
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusAccepted)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.waitBackground(ctx); err != nil {
+		t.Fatalf("waitBackground() error = %v", err)
+	}
+
+	request := findSpan(t, exp, "echo-background-handler")
+	task := findSpan(t, exp, "echo-background-task")
+	if task.SpanContext.TraceID() == request.SpanContext.TraceID() || task.Parent.IsValid() {
+		t.Errorf("task span is in trace %s under %s, want a new root", task.SpanContext.TraceID(), task.Parent.SpanID())
+	}
+	if len(task.Links) != 1 || task.Links[0].SpanContext.TraceID() != request.SpanContext.TraceID() {
+		t.Fatalf("task links = %+v, want one link to the request trace %s", task.Links, request.SpanContext.TraceID())
+	}
+	if task.Links[0].SpanContext.SpanID() != request.SpanContext.SpanID() {
+		t.Errorf("task links to span %s, want the request span %s", task.Links[0].SpanContext.SpanID(), request.SpanContext.SpanID())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// parseBasePath normalizes BASE_PATH to a leading slash and no trailing
+// slash, so "api/" becomes "/api". An empty value or "/" means no prefix.
+func parseBasePath(v string) (string, error) {
+	v = strings.TrimRight(v, "/")
+	if v == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(v, "/") {
+		v = "/" + v
+	}
+	if strings.ContainsAny(v, "?#{}") {
+		return "", fmt.Errorf("invalid BASE_PATH %q: must be a plain path", v)
+	}
+	return v, nil
+}
+
+// stripBasePath returns a shallow copy of r with prefix removed from its
+// path, and false if the path is outside prefix. The prefix only matches
+// whole segments, so /api does not match /apis.
+func stripBasePath(r *http.Request, prefix string) (*http.Request, bool) {
+	p, ok := trimSegmentPrefix(r.URL.Path, prefix)
+	if !ok {
+		return nil, false
+	}
+	rp, ok := trimSegmentPrefix(r.URL.RawPath, prefix)
+	if r.URL.RawPath != "" && !ok {
+		return nil, false
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	if r.URL.RawPath != "" {
+		r2.URL.RawPath = rp
+	}
+	return r2, true
+}
+
+func trimSegmentPrefix(path, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	switch {
+	case !ok:
+		return "", false
+	case rest == "":
+		return "/", true
+	case rest[0] == '/':
+		return rest, true
+	default:
+		return "", false
+	}
+}
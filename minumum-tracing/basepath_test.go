@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseBasePath(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"/", "", false},
+		{"api", "/api", false},
+		{"/api/", "/api", false},
+		{"/api/v1", "/api/v1", false},
+		{"/api?x", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseBasePath(tt.v)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseBasePath(%q) = %q, %v, want %q, error %t", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	t.Setenv("BASE_PATH", "/api")
+	s, exp := newTestServer(t, testConfig(t))
+	tests := []struct {
+		target     string
+		wantStatus int
+	}{
+		{"/api/echo/hi", http.StatusOK},
+		{"/api/_ah/health", http.StatusOK},
+		{"/api/echo/a%2Fb", http.StatusOK},
+		{"/echo/hi", http.StatusNotFound},
+		{"/_ah/health", http.StatusNotFound},
+		{"/apis/echo/hi", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if w := serve(s, httptest.NewRequest("GET", tt.target, nil)); w.Code != tt.wantStatus {
+			t.Errorf("GET %s status = %d, want %d", tt.target, w.Code, tt.wantStatus)
+		}
+	}
+
+	route, _ := spanAttr(findSpan(t, exp, "echo-handler"), "http.route")
+	if route.AsString() != "/api/echo/{message}" {
+		t.Errorf("http.route = %q, want /api/echo/{message}", route.AsString())
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBatchSize caps the number of messages accepted by POST /echo/batch.
+const maxBatchSize = 100
+
+// BatchItem is the result for one message of a batch echo request. Exactly
+// one of Message and Error is set.
+type BatchItem struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+var errEmptyMessage = errors.New("message is required")
+
+// handleEchoBatch echoes a JSON array of requests, processing each one in its
+// own child span. A failing item is reported in its slot of the response
+// without aborting the rest of the batch.
+func (s *server) handleEchoBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	var reqs []Request
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if len(reqs) > maxBatchSize {
+		writeJSONError(w, http.StatusBadRequest, "too many messages in batch", traceIDFromContext(ctx))
+		return
+	}
+	span.SetAttributes(attribute.Int("batch.size", len(reqs)))
+	// An oversized message rejects the whole batch, as it would a single
+	// echo, rather than being reported in its slot.
+	for _, req := range reqs {
+		if !s.checkMessageLength(w, r, req.Message) {
+			return
+		}
+	}
+
+	items := make([]any, len(reqs))
+	failed := 0
+	for i, req := range reqs {
+		if err := s.echoBatchItem(ctx, i, req); err != nil {
+			items[i] = BatchItem{Error: err.Error()}
+			failed++
+			continue
+		}
+		items[i] = s.withResponseField(BatchItem{Message: req.Message})
+	}
+	span.SetAttributes(attribute.Int("batch.failed", failed))
+
+	slog.InfoContext(ctx, "received echo batch request", "size", len(reqs), "failed", failed)
+	s.writeJSON(ctx, w, items)
+}
+
+func (s *server) echoBatchItem(ctx context.Context, index int, req Request) error {
+	_, span := s.tracer.Start(ctx, "echo-batch-item", trace.WithAttributes(
+		attribute.Int("batch.index", index),
+	))
+	defer span.End()
+
+	if req.Message == "" {
+		span.RecordError(errEmptyMessage)
+		span.SetStatus(codes.Error, errEmptyMessage.Error())
+		return errEmptyMessage
+	}
+	span.SetAttributes(s.messageAttributes(req.Message)...)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	ctx := context.Background()
+	failure := errors.New("downstream failed")
+	b := newCircuitBreaker(2, 20*time.Millisecond)
+
+	for range 2 {
+		if !b.allow(ctx) {
+			t.Fatal("closed breaker rejected a call")
+		}
+		b.record(ctx, failure)
+	}
+	if b.state != breakerOpen {
+		t.Fatalf("state after %d failures = %v, want open", b.threshold, b.state)
+	}
+	if b.allow(ctx) {
+		t.Error("open breaker allowed a call within the cooldown")
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	if !b.allow(ctx) {
+		t.Fatal("breaker rejected the probe after the cooldown")
+	}
+	if b.state != breakerHalfOpen {
+		t.Errorf("state during the probe = %v, want half-open", b.state)
+	}
+	if b.allow(ctx) {
+		t.Error("half-open breaker allowed a second call during the probe")
+	}
+	b.record(ctx, failure)
+	if b.state != breakerOpen {
+		t.Fatalf("state after a failed probe = %v, want open", b.state)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	b.allow(ctx)
+	b.record(ctx, nil)
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Errorf("state after a successful probe = %v with %d failures, want closed with none", b.state, b.failures)
+	}
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	ctx := context.Background()
+	b := newCircuitBreaker(1, 0)
+	b.allow(ctx)
+	b.record(ctx, errors.New("downstream failed"))
+
+	if !b.allow(ctx) {
+		t.Fatal("breaker rejected the probe after the cooldown")
+	}
+	b.release()
+
+	if b.state != breakerHalfOpen {
+		t.Errorf("state after a released probe = %v, want half-open", b.state)
+	}
+	if !b.allow(ctx) {
+		t.Error("released probe still blocks the next one")
+	}
+}
+
+func TestEchoEnrichedBreaker(t *testing.T) {
+	t.Setenv("ENRICH_FAILURE_RATE", "1")
+	t.Setenv("BREAKER_THRESHOLD", "2")
+	t.Setenv("BREAKER_COOLDOWN", "1m")
+	s, exp := newTestServer(t, testConfig(t))
+
+	var codes []int
+	for range 3 {
+		codes = append(codes, serve(s, httptest.NewRequest("GET", "/echo/hi/enriched", nil)).Code)
+	}
+
+	want := []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusServiceUnavailable}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", codes, want)
+		}
+	}
+	var changes int
+	for _, span := range exp.GetSpans() {
+		for _, e := range span.Events {
+			if e.Name == "circuit_breaker.state_change" {
+				changes++
+			}
+		}
+	}
+	if changes != 1 {
+		t.Errorf("recorded %d state changes, want 1", changes)
+	}
+}
+
+func TestEchoEnrichedCanceled(t *testing.T) {
+	t.Setenv("BREAKER_THRESHOLD", "1")
+	s, _ := newTestServer(t, testConfig(t))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi/enriched", nil).WithContext(ctx))
+
+	if s.breaker.state != breakerClosed || s.breaker.failures != 0 {
+		t.Errorf("breaker after a cancelled call = %v with %d failures, want closed with none", s.breaker.state, s.breaker.failures)
+	}
+}
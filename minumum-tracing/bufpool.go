@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize keeps a single large response from pinning its buffer
+// in the pool for good.
+const maxPooledBufferSize = 64 << 10
+
+// bufferPool recycles the buffers responses are encoded into when
+// POOL_BUFFERS is set, trading a little bookkeeping for less garbage per
+// request.
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns buf to the pool. buf must not be used afterwards.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWriteJSONPooledConcurrent(t *testing.T) {
+	s := &server{poolBuffers: true}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := fmt.Sprintf("message %d", i)
+			for range 20 {
+				w := httptest.NewRecorder()
+				s.writeJSON(context.Background(), w, Response{Message: want})
+				var got Response
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Message != want {
+					t.Errorf("body = %q (%v), want the message %q", w.Body.String(), err, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// discardWriter is a ResponseWriter throwing the response away, so that the
+// benchmarks only measure encoding.
+type discardWriter struct{ header http.Header }
+
+func (w discardWriter) Header() http.Header       { return w.header }
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (discardWriter) WriteHeader(int)             {}
+
+func benchmarkEncode(b *testing.B, pooled bool) {
+	s := &server{poolBuffers: pooled}
+	resp := Response{Message: "hello, world"}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		w := discardWriter{header: make(http.Header)}
+		for pb.Next() {
+			s.writeJSON(ctx, w, resp)
+		}
+	})
+}
+
+func BenchmarkEncodePooled(b *testing.B)   { benchmarkEncode(b, true) }
+func BenchmarkEncodeUnpooled(b *testing.B) { benchmarkEncode(b, false) }
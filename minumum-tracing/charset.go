@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// charsetQuality returns the quality the Accept-Charset header assigns to
+// charset, preferring an exact match over the * wildcard. An empty header
+// accepts any charset.
+func charsetQuality(acceptCharset, charset string) float64 {
+	if strings.TrimSpace(acceptCharset) == "" {
+		return 1
+	}
+	q, exact := 0.0, false
+	for _, part := range strings.Split(acceptCharset, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		match := strings.EqualFold(name, charset)
+		if !match && (name != "*" || exact) {
+			continue
+		}
+		exact, q = match, 1
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+	}
+	return q
+}
+
+// acceptCharset answers 406 when Accept-Charset rules out UTF-8, the only
+// charset responses are written in, and otherwise makes textual responses
+// declare charset=utf-8 in their Content-Type. The negotiated charset is
+// recorded on the active span.
+func acceptCharset(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+		if charsetQuality(r.Header.Get("Accept-Charset"), "utf-8") <= 0 {
+			span.SetAttributes(attribute.Bool("http.charset.unsupported", true))
+			writeJSONError(w, http.StatusNotAcceptable, "only the utf-8 charset is supported", traceIDFromContext(ctx))
+			return
+		}
+		span.SetAttributes(attribute.String("http.response.charset", "utf-8"))
+		next.ServeHTTP(&charsetWriter{ResponseWriter: w}, r)
+	})
+}
+
+// charsetWriter adds charset=utf-8 to a textual Content-Type missing a
+// charset when the header is written, unless keepContentType was called.
+type charsetWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+	// verbatim is set from the handler, which a request timeout may leave
+	// running while the timeout response is written.
+	verbatim atomic.Bool
+}
+
+// keepContentType makes acceptCharset leave the Content-Type written through
+// w as it is, for handlers such as the raw echo that must send back the type
+// they were given. It looks for the charsetWriter through the writers
+// wrapping it, the way http.ResponseController does.
+func keepContentType(w http.ResponseWriter) {
+	for {
+		switch t := w.(type) {
+		case *charsetWriter:
+			t.verbatim.Store(true)
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = t.Unwrap()
+		default:
+			return
+		}
+	}
+}
+
+func (cw *charsetWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		h := cw.Header()
+		ct := h.Get("Content-Type")
+		textual := strings.HasPrefix(ct, "text/") || strings.HasPrefix(ct, "application/json")
+		if textual && !cw.verbatim.Load() && !strings.Contains(strings.ToLower(ct), "charset=") {
+			h.Set("Content-Type", ct+"; charset=utf-8")
+		}
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *charsetWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streaming responses.
+func (cw *charsetWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
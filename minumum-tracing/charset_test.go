@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCharsetQuality(t *testing.T) {
+	tests := []struct {
+		header string
+		want   float64
+	}{
+		{"", 1},
+		{"utf-8", 1},
+		{"UTF-8;q=0.5", 0.5},
+		{"iso-8859-1", 0},
+		{"iso-8859-1, *;q=0.3", 0.3},
+		{"*;q=0.8, utf-8;q=0", 0},
+	}
+	for _, tt := range tests {
+		if got := charsetQuality(tt.header, "utf-8"); got != tt.want {
+			t.Errorf("charsetQuality(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAcceptCharset(t *testing.T) {
+	// The request timeout buffers the response behind another writer, which
+	// keepContentType must see through.
+	t.Setenv("REQUEST_TIMEOUT", "5s")
+	s, _ := newTestServer(t, testConfig(t))
+
+	if w := serve(s, httptest.NewRequest("GET", "/echo/hi", nil)); w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("echo Content-Type = %q, want the charset added", w.Header().Get("Content-Type"))
+	}
+
+	r := httptest.NewRequest("POST", "/echo/raw", strings.NewReader(`{"message":"hi"}`))
+	r.Header.Set("Content-Type", "application/json")
+	if w := serve(s, r); w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("raw echo Content-Type = %q, want it verbatim", w.Header().Get("Content-Type"))
+	}
+
+	r = httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("Accept-Charset", "iso-8859-1")
+	if w := serve(s, r); w.Code != http.StatusNotAcceptable {
+		t.Errorf("status = %d, want %d when utf-8 is not acceptable", w.Code, http.StatusNotAcceptable)
+	}
+}
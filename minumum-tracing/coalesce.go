@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// coalescer lets concurrent echo requests for the same message share one
+// simulated processing delay instead of each waiting out their own. Echoing
+// is cheap, so this mostly illustrates the single-flight pattern.
+type coalescer struct {
+	group singleflight.Group
+}
+
+// wait blocks for delay, joining the wait already in progress for key when
+// there is one, in which case coalesced is true. It returns early with the
+// context error once ctx is done; a shared wait carries on for the other
+// callers. A nil coalescer waits on its own.
+func (c *coalescer) wait(ctx context.Context, key string, delay time.Duration) (coalesced bool, err error) {
+	if c == nil {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(delay):
+			return false, nil
+		}
+	}
+
+	led := false
+	done := c.group.DoChan(key, func() (any, error) {
+		led = true
+		time.Sleep(delay)
+		return nil, nil
+	})
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case <-done:
+		return !led, nil
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// serveConcurrently sends a GET request for each target to s at once and
+// returns the status codes.
+func serveConcurrently(s http.Handler, targets []string) []int {
+	codes := make([]int, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serve(s, httptest.NewRequest("GET", target, nil)).Code
+		}()
+	}
+	wg.Wait()
+	return codes
+}
+
+func TestCoalesceRequests(t *testing.T) {
+	const delay = 200 * time.Millisecond
+	t.Setenv("ECHO_DELAY", delay.String())
+	t.Setenv("COALESCE_REQUESTS", "true")
+	s, exp := newTestServer(t, testConfig(t))
+
+	start := time.Now()
+	codes := serveConcurrently(s, []string{"/echo/hi", "/echo/hi", "/echo/hi", "/echo/hi"})
+	elapsed := time.Since(start)
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d status = %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	coalesced := 0
+	for _, span := range exp.GetSpans() {
+		if v, ok := spanAttr(span, "coalesced"); ok && v.AsBool() {
+			coalesced++
+		}
+	}
+	if coalesced != 3 {
+		t.Errorf("coalesced %d requests, want 3 joining the first", coalesced)
+	}
+	if elapsed >= 2*delay {
+		t.Errorf("requests took %s, want them to share one %s delay", elapsed, delay)
+	}
+}
+
+func TestCoalesceRequestsDifferentMessages(t *testing.T) {
+	t.Setenv("ECHO_DELAY", "50ms")
+	t.Setenv("COALESCE_REQUESTS", "true")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serveConcurrently(s, []string{"/echo/a", "/echo/b", "/echo/c"})
+
+	for _, span := range exp.GetSpans() {
+		if _, ok := spanAttr(span, "coalesced"); ok {
+			t.Errorf("span %s coalesced, want requests for different messages kept apart", span.Name)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the service configuration, read once from the environment at
+// startup.
+type Config struct {
+	Port string
+	// Addr is the address listened on, which comes from AddrSource: the
+	// -addr flag, PORT or the default port.
+	Addr       string
+	AddrSource string
+	ProjectID  string
+
+	LogLevel  slog.Level
+	LogSource bool
+	LogOutput string
+	LogFormat string
+	// AccessLogSampleRate is the fraction of successful requests logged.
+	AccessLogSampleRate float64
+
+	Exporter             string
+	UseCloudTrace        bool
+	SpanFile             string
+	ExporterInitAttempts int
+	ExporterInitBackoff  time.Duration
+	SpanQueueSize        int
+	SpanBatchSize        int
+	SpanBatchDelay       time.Duration
+	SpanExportTimeout    time.Duration
+	Sampler              string
+	SamplerRatio         float64
+	Propagators          string
+	UntracedRoutes       []string
+	SamplingRules        []samplingRule
+	InMemorySpans        bool
+	DisableTracing       bool
+	DetailedSpans        bool
+	TraceIDHeader        string
+
+	ShutdownTimeout   time.Duration
+	DrainDelay        time.Duration
+	DrainTimeout      time.Duration
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxConnections    int
+	MaxHeaderBytes    int
+	RequestTimeout    time.Duration
+	SlowThreshold     time.Duration
+	EnableH2C         bool
+	TLSCertFile       string
+	TLSKeyFile        string
+
+	EchoDelayMin         time.Duration
+	EchoDelayMax         time.Duration
+	EchoDelaySeed        uint64
+	MaxMessageLength     int
+	MaxMessageAttrLength int
+	MaxBodyBytes         int64
+	GzipMinBytes         int
+
+	IdempotencyTTL       time.Duration
+	IdempotencyCacheSize int
+
+	// StoreSize bounds the entries of the /store endpoints.
+	StoreSize int
+
+	RateLimit   float64
+	RateBurst   int
+	MaxInflight int
+	// TrustedProxyHops is how many proxies in front of the service append
+	// to X-Forwarded-For.
+	TrustedProxyHops int
+
+	BreakerThreshold  int
+	BreakerCooldown   time.Duration
+	EnrichFailureRate float64
+	OutboundURL       string
+
+	BasePath       string
+	HealthPath     string
+	AllowedOrigins []string
+	AllowedTenants []string
+	DefaultHeaders http.Header
+	EnablePprof    bool
+	EnableDebug    bool
+	RecordRequests bool
+	PrettyJSON     bool
+	ResponseField  string
+	PoolBuffers    bool
+	Coalesce       bool
+	AdminToken     string
+}
+
+// LoadConfig reads the configuration from the environment, applying defaults
+// for unset variables. Every invalid variable is reported in the returned
+// error, alongside a Config holding defaults in their place.
+func LoadConfig() (Config, error) {
+	var (
+		errs []error
+		err  error
+		cfg  = Config{
+			ProjectID:      os.Getenv("GOOGLE_CLOUD_PROJECT"),
+			SpanFile:       os.Getenv("SPAN_FILE"),
+			OutboundURL:    os.Getenv("OUTBOUND_URL"),
+			AllowedOrigins: parseAllowedOrigins(os.Getenv("ALLOWED_ORIGINS")),
+			AllowedTenants: parseList(os.Getenv("ALLOWED_TENANTS")),
+			AdminToken:     os.Getenv("ADMIN_TOKEN"),
+		}
+	)
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	cfg.Port, cfg.AddrSource = os.Getenv("PORT"), "PORT"
+	if cfg.Port == "" {
+		cfg.Port, cfg.AddrSource = "8080", "default"
+	}
+	if n, err := strconv.Atoi(cfg.Port); err != nil || n < 1 || n > 65535 {
+		check(fmt.Errorf("invalid PORT %q: must be between 1 and 65535", cfg.Port))
+		cfg.Port = "8080"
+	}
+	cfg.Addr = ":" + cfg.Port
+
+	cfg.LogLevel, err = levelEnv("LOG_LEVEL", slog.LevelInfo)
+	check(err)
+	cfg.LogSource, err = boolEnv("LOG_SOURCE")
+	check(err)
+	// JSON is what Cloud Logging parses into structured entries; text is
+	// easier to read locally.
+	cfg.LogFormat = os.Getenv("LOG_FORMAT")
+	switch cfg.LogFormat {
+	case "":
+		cfg.LogFormat = "json"
+	case "json", "text":
+	default:
+		check(fmt.Errorf("invalid LOG_FORMAT %q: must be json or text", cfg.LogFormat))
+		cfg.LogFormat = "json"
+	}
+	cfg.LogOutput = os.Getenv("LOG_OUTPUT")
+	if cfg.LogOutput == "" {
+		cfg.LogOutput = "stdout"
+	}
+	cfg.AccessLogSampleRate, err = floatEnv("ACCESS_LOG_SAMPLE_RATE", 1)
+	check(err)
+	if cfg.AccessLogSampleRate > 1 {
+		check(fmt.Errorf("invalid ACCESS_LOG_SAMPLE_RATE %v: must be between 0 and 1", cfg.AccessLogSampleRate))
+		cfg.AccessLogSampleRate = 1
+	}
+
+	cfg.Exporter, err = exporterName()
+	check(err)
+	cfg.UseCloudTrace, err = boolEnv("USE_CLOUD_TRACE")
+	check(err)
+	// Creating the exporter is retried since the collector may still be
+	// starting.
+	cfg.ExporterInitAttempts, err = intEnv("EXPORTER_INIT_ATTEMPTS", 3)
+	check(err)
+	cfg.ExporterInitBackoff, err = durationEnv("EXPORTER_INIT_BACKOFF", 500*time.Millisecond)
+	check(err)
+	cfg.SpanQueueSize, err = intEnv("OTEL_BSP_MAX_QUEUE_SIZE", defaultSpanQueueSize)
+	check(err)
+	if cfg.SpanQueueSize < 1 {
+		check(fmt.Errorf("invalid OTEL_BSP_MAX_QUEUE_SIZE %d: must be at least 1", cfg.SpanQueueSize))
+		cfg.SpanQueueSize = defaultSpanQueueSize
+	}
+	cfg.SpanBatchSize, err = intEnv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", defaultSpanBatchSize)
+	check(err)
+	if cfg.SpanBatchSize < 1 || cfg.SpanBatchSize > cfg.SpanQueueSize {
+		check(fmt.Errorf("invalid OTEL_BSP_MAX_EXPORT_BATCH_SIZE %d: must be between 1 and OTEL_BSP_MAX_QUEUE_SIZE", cfg.SpanBatchSize))
+		cfg.SpanBatchSize = min(defaultSpanBatchSize, cfg.SpanQueueSize)
+	}
+	// The OTEL_BSP_* delays are in milliseconds, as the specification says.
+	cfg.SpanBatchDelay, err = millisecondsEnv("OTEL_BSP_SCHEDULE_DELAY", defaultSpanBatchDelay)
+	check(err)
+	cfg.SpanExportTimeout, err = millisecondsEnv("OTEL_BSP_EXPORT_TIMEOUT", defaultSpanExportTimeout)
+	check(err)
+	cfg.Sampler = os.Getenv("OTEL_TRACES_SAMPLER")
+	if cfg.Sampler == "" {
+		cfg.Sampler = "always_on"
+	}
+	cfg.SamplerRatio = 1
+	if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		ratio, err := strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			check(fmt.Errorf("invalid OTEL_TRACES_SAMPLER_ARG %q: must be a number between 0 and 1", arg))
+		} else {
+			cfg.SamplerRatio = ratio
+		}
+	}
+	cfg.SamplingRules, err = parseSamplingRules(os.Getenv("SAMPLING_RULES"))
+	check(err)
+	cfg.Propagators = os.Getenv("OTEL_PROPAGATORS")
+	if cfg.Propagators == "" {
+		cfg.Propagators = defaultPropagators
+	}
+	cfg.InMemorySpans, err = boolEnv("IN_MEMORY_SPANS")
+	check(err)
+	cfg.DisableTracing, err = boolEnv("DISABLE_TRACING")
+	check(err)
+	if cfg.DisableTracing && cfg.InMemorySpans {
+		check(errors.New("DISABLE_TRACING and IN_MEMORY_SPANS cannot both be set"))
+	}
+	cfg.DetailedSpans, err = boolEnv("DETAILED_SPANS")
+	check(err)
+	cfg.TraceIDHeader = http.CanonicalHeaderKey(os.Getenv("TRACE_ID_HEADER"))
+	if cfg.TraceIDHeader == "" {
+		cfg.TraceIDHeader = defaultTraceIDHeader
+	}
+
+	cfg.ShutdownTimeout, err = durationEnv("SHUTDOWN_TIMEOUT", 5*time.Second)
+	check(err)
+	cfg.DrainDelay, err = durationEnv("DRAIN_DELAY", 0)
+	check(err)
+	// A zero DRAIN_TIMEOUT shuts down without waiting for the in-flight
+	// requests to finish first, leaving them to SHUTDOWN_TIMEOUT.
+	cfg.DrainTimeout, err = durationEnv("DRAIN_TIMEOUT", 0)
+	check(err)
+	cfg.ReadTimeout, err = durationEnv("READ_TIMEOUT", defaultReadTimeout)
+	check(err)
+	cfg.ReadHeaderTimeout, err = durationEnv("READ_HEADER_TIMEOUT", defaultReadHeaderTimeout)
+	check(err)
+	cfg.WriteTimeout, err = durationEnv("WRITE_TIMEOUT", defaultWriteTimeout)
+	check(err)
+	cfg.IdleTimeout, err = durationEnv("IDLE_TIMEOUT", defaultIdleTimeout)
+	check(err)
+	// A zero MAX_CONNECTIONS leaves the number of connections unlimited.
+	cfg.MaxConnections, err = intEnv("MAX_CONNECTIONS", 0)
+	check(err)
+	// A zero MAX_HEADER_BYTES leaves the net/http default of 1 MiB.
+	cfg.MaxHeaderBytes, err = intEnv("MAX_HEADER_BYTES", 0)
+	check(err)
+	cfg.RequestTimeout, err = durationEnv("REQUEST_TIMEOUT", 0)
+	check(err)
+	cfg.SlowThreshold, err = durationEnv("SLOW_THRESHOLD", 0)
+	check(err)
+	cfg.EnableH2C, err = boolEnv("ENABLE_H2C")
+	check(err)
+	cfg.TLSCertFile, cfg.TLSKeyFile = os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		check(errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together"))
+	}
+
+	// ECHO_DELAY is a fixed delay, ECHO_DELAY_MIN and ECHO_DELAY_MAX a range.
+	fixedDelay, err := durationEnv("ECHO_DELAY", 0)
+	check(err)
+	cfg.EchoDelayMin, err = durationEnv("ECHO_DELAY_MIN", fixedDelay)
+	check(err)
+	cfg.EchoDelayMax, err = durationEnv("ECHO_DELAY_MAX", cfg.EchoDelayMin)
+	check(err)
+	if cfg.EchoDelayMax < cfg.EchoDelayMin {
+		check(fmt.Errorf("invalid ECHO_DELAY_MAX %s: must not be less than ECHO_DELAY_MIN %s", cfg.EchoDelayMax, cfg.EchoDelayMin))
+		cfg.EchoDelayMax = cfg.EchoDelayMin
+	}
+	cfg.EchoDelaySeed = rand.Uint64()
+	if v := os.Getenv("ECHO_DELAY_SEED"); v != "" {
+		if seed, err := strconv.ParseUint(v, 10, 64); err != nil {
+			check(fmt.Errorf("invalid ECHO_DELAY_SEED %q: must be a non-negative integer", v))
+		} else {
+			cfg.EchoDelaySeed = seed
+		}
+	}
+	cfg.MaxMessageLength, err = intEnv("MAX_MESSAGE_LENGTH", defaultMaxMessageLength)
+	check(err)
+	cfg.MaxMessageAttrLength, err = intEnv("SPAN_MESSAGE_MAX_LENGTH", defaultMaxMessageAttrLength)
+	check(err)
+	if cfg.MaxMessageAttrLength < 1 {
+		check(fmt.Errorf("invalid SPAN_MESSAGE_MAX_LENGTH %d: must be at least 1", cfg.MaxMessageAttrLength))
+		cfg.MaxMessageAttrLength = defaultMaxMessageAttrLength
+	}
+	maxBodyBytes, err := intEnv("MAX_BODY_BYTES", defaultMaxBodyBytes)
+	check(err)
+	cfg.MaxBodyBytes = int64(maxBodyBytes)
+	cfg.GzipMinBytes, err = intEnv("GZIP_MIN_BYTES", defaultGzipMinBytes)
+	check(err)
+
+	// A zero IDEMPOTENCY_TTL disables Idempotency-Key support.
+	cfg.IdempotencyTTL, err = durationEnv("IDEMPOTENCY_TTL", 10*time.Minute)
+	check(err)
+	cfg.IdempotencyCacheSize, err = intEnv("IDEMPOTENCY_CACHE_SIZE", 1000)
+	check(err)
+	if cfg.IdempotencyCacheSize < 1 {
+		check(fmt.Errorf("invalid IDEMPOTENCY_CACHE_SIZE %d: must be at least 1", cfg.IdempotencyCacheSize))
+		cfg.IdempotencyCacheSize = 1000
+	}
+	// A zero STORE_SIZE disables the /store endpoints.
+	cfg.StoreSize, err = intEnv("STORE_SIZE", 1000)
+	check(err)
+
+	cfg.RateLimit, err = floatEnv("RATE_LIMIT", 0)
+	check(err)
+	cfg.RateBurst, err = intEnv("RATE_BURST", 1)
+	check(err)
+	cfg.RateBurst = max(cfg.RateBurst, 1)
+	// A zero TRUSTED_PROXY_HOPS ignores X-Forwarded-For.
+	cfg.TrustedProxyHops, err = intEnv("TRUSTED_PROXY_HOPS", 1)
+	check(err)
+	// A zero MAX_INFLIGHT disables load shedding.
+	cfg.MaxInflight, err = intEnv("MAX_INFLIGHT", 0)
+	check(err)
+
+	cfg.BreakerThreshold, err = intEnv("BREAKER_THRESHOLD", 5)
+	check(err)
+	cfg.BreakerCooldown, err = durationEnv("BREAKER_COOLDOWN", 30*time.Second)
+	check(err)
+	cfg.EnrichFailureRate, err = floatEnv("ENRICH_FAILURE_RATE", 0)
+	check(err)
+	if cfg.EnrichFailureRate > 1 {
+		check(fmt.Errorf("invalid ENRICH_FAILURE_RATE %v: must be between 0 and 1", cfg.EnrichFailureRate))
+		cfg.EnrichFailureRate = 0
+	}
+
+	cfg.BasePath, err = parseBasePath(os.Getenv("BASE_PATH"))
+	check(err)
+	cfg.HealthPath = os.Getenv("HEALTH_PATH")
+	if cfg.HealthPath == "" {
+		cfg.HealthPath = defaultHealthPath
+	}
+	if !strings.HasPrefix(cfg.HealthPath, "/") || strings.ContainsAny(cfg.HealthPath, " ?#{}") {
+		check(fmt.Errorf("invalid HEALTH_PATH %q: must be a plain path starting with /", cfg.HealthPath))
+		cfg.HealthPath = defaultHealthPath
+	}
+	if slices.Contains(reservedPaths, cfg.HealthPath) {
+		check(fmt.Errorf("invalid HEALTH_PATH %q: clashes with another route", cfg.HealthPath))
+		cfg.HealthPath = defaultHealthPath
+	}
+	// The health checks are polled constantly, so they are not traced unless
+	// UNTRACED_ROUTES says otherwise.
+	cfg.UntracedRoutes = parseList(os.Getenv("UNTRACED_ROUTES"))
+	if cfg.UntracedRoutes == nil {
+		cfg.UntracedRoutes = []string{cfg.BasePath + cfg.HealthPath, cfg.BasePath + "/healthz"}
+	}
+	cfg.DefaultHeaders, err = parseDefaultHeaders(os.Getenv("DEFAULT_HEADERS"))
+	check(err)
+	cfg.EnablePprof, err = boolEnv("ENABLE_PPROF")
+	check(err)
+	cfg.EnableDebug, err = boolEnv("ENABLE_DEBUG")
+	check(err)
+	cfg.RecordRequests, err = boolEnv("RECORD_REQUESTS")
+	check(err)
+	cfg.PrettyJSON, err = boolEnv("PRETTY_JSON")
+	check(err)
+	cfg.ResponseField = os.Getenv("RESPONSE_FIELD_NAME")
+	if cfg.ResponseField == "" {
+		cfg.ResponseField = defaultResponseField
+	}
+	cfg.PoolBuffers, err = boolEnv("POOL_BUFFERS")
+	check(err)
+	cfg.Coalesce, err = boolEnv("COALESCE_REQUESTS")
+	check(err)
+
+	return cfg, errors.Join(errs...)
+}
+
+// overrideAddr makes the server listen on addr, given as host:port, instead
+// of the address derived from PORT.
+func (c *Config) overrideAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid -addr %q: must be host:port", addr)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid -addr %q: port must be between 1 and 65535", addr)
+	}
+	c.Addr, c.AddrSource = addr, "-addr flag"
+	return nil
+}
+
+// LogValue summarizes the configuration for the startup log, redacting
+// secrets.
+func (c Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("addr", c.Addr),
+		slog.String("addr_source", c.AddrSource),
+		slog.String("project_id", c.ProjectID),
+		slog.String("log_level", c.LogLevel.String()),
+		slog.Bool("log_source", c.LogSource),
+		slog.String("log_output", c.LogOutput),
+		slog.String("log_format", c.LogFormat),
+		slog.Float64("access_log_sample_rate", c.AccessLogSampleRate),
+		slog.String("exporter", c.Exporter),
+		slog.String("span_file", c.SpanFile),
+		slog.Int("exporter_init_attempts", c.ExporterInitAttempts),
+		slog.Int("span_queue_size", c.SpanQueueSize),
+		slog.Int("span_batch_size", c.SpanBatchSize),
+		slog.String("span_batch_delay", c.SpanBatchDelay.String()),
+		slog.String("span_export_timeout", c.SpanExportTimeout.String()),
+		slog.String("sampler", c.Sampler),
+		slog.Float64("sampler_ratio", c.SamplerRatio),
+		slog.String("propagators", c.Propagators),
+		slog.Any("untraced_routes", c.UntracedRoutes),
+		slog.Any("sampling_rules", c.SamplingRules),
+		slog.Bool("in_memory_spans", c.InMemorySpans),
+		slog.Bool("disable_tracing", c.DisableTracing),
+		slog.Bool("detailed_spans", c.DetailedSpans),
+		slog.String("trace_id_header", c.TraceIDHeader),
+		slog.String("shutdown_timeout", c.ShutdownTimeout.String()),
+		slog.String("drain_delay", c.DrainDelay.String()),
+		slog.String("drain_timeout", c.DrainTimeout.String()),
+		slog.String("read_timeout", c.ReadTimeout.String()),
+		slog.String("read_header_timeout", c.ReadHeaderTimeout.String()),
+		slog.String("write_timeout", c.WriteTimeout.String()),
+		slog.String("idle_timeout", c.IdleTimeout.String()),
+		slog.Int("max_connections", c.MaxConnections),
+		slog.Int("max_header_bytes", c.MaxHeaderBytes),
+		slog.String("request_timeout", c.RequestTimeout.String()),
+		slog.String("slow_threshold", c.SlowThreshold.String()),
+		slog.Bool("h2c", c.EnableH2C),
+		slog.String("tls_cert_file", c.TLSCertFile),
+		slog.String("tls_key_file", c.TLSKeyFile),
+		slog.String("echo_delay_min", c.EchoDelayMin.String()),
+		slog.String("echo_delay_max", c.EchoDelayMax.String()),
+		slog.Int("max_message_length", c.MaxMessageLength),
+		slog.Int("span_message_max_length", c.MaxMessageAttrLength),
+		slog.Int64("max_body_bytes", c.MaxBodyBytes),
+		slog.Int("gzip_min_bytes", c.GzipMinBytes),
+		slog.String("idempotency_ttl", c.IdempotencyTTL.String()),
+		slog.Int("idempotency_cache_size", c.IdempotencyCacheSize),
+		slog.Int("store_size", c.StoreSize),
+		slog.Float64("rate_limit", c.RateLimit),
+		slog.Int("rate_burst", c.RateBurst),
+		slog.Int("max_inflight", c.MaxInflight),
+		slog.Int("trusted_proxy_hops", c.TrustedProxyHops),
+		slog.Int("breaker_threshold", c.BreakerThreshold),
+		slog.String("breaker_cooldown", c.BreakerCooldown.String()),
+		slog.Float64("enrich_failure_rate", c.EnrichFailureRate),
+		slog.String("outbound_url", redactURL(c.OutboundURL)),
+		slog.String("base_path", c.BasePath),
+		slog.String("health_path", c.HealthPath),
+		slog.Any("allowed_origins", c.AllowedOrigins),
+		slog.Any("allowed_tenants", c.AllowedTenants),
+		slog.Any("default_headers", c.DefaultHeaders),
+		slog.Bool("pprof", c.EnablePprof),
+		slog.Bool("debug", c.EnableDebug),
+		slog.Bool("record_requests", c.RecordRequests),
+		slog.Bool("pretty_json", c.PrettyJSON),
+		slog.String("response_field_name", c.ResponseField),
+		slog.Bool("pool_buffers", c.PoolBuffers),
+		slog.Bool("coalesce_requests", c.Coalesce),
+		slog.String("admin_token", redact(c.AdminToken)),
+	)
+}
+
+// redacted returns the fields reported by LogValue as a map, for serving the
+// configuration as JSON with the same secrets redacted.
+func (c Config) redacted() map[string]any {
+	attrs := c.LogValue().Group()
+	m := make(map[string]any, len(attrs))
+	for _, a := range attrs {
+		m[a.Key] = a.Value.Any()
+	}
+	return m
+}
+
+// redact hides a secret while still showing whether it is set.
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// redactURL hides the password of a URL, if any.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redact(raw)
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// correlation bundles the identifiers that tie a log entry or response to
+// the request and trace it belongs to. Empty fields were not set on the
+// request.
+type correlation struct {
+	RequestID string
+	TenantID  string
+	TraceID   string
+	SpanID    string
+	Sampled   bool
+}
+
+// correlationFromContext collects the correlation identifiers of ctx. It is
+// read from the context every time rather than stored once, because the
+// active span changes as the request goes through nested spans.
+func correlationFromContext(ctx context.Context) correlation {
+	c := correlation{
+		RequestID: requestIDFromContext(ctx),
+		TenantID:  tenantFromContext(ctx),
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		c.TraceID = sc.TraceID().String()
+		c.SpanID = sc.SpanID().String()
+		c.Sampled = sc.IsSampled()
+	}
+	return c
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestCorrelationFromContext(t *testing.T) {
+	ctx := context.WithValue(context.Background(), requestIDKey{}, "req-1")
+	ctx = context.WithValue(ctx, tenantKey{}, "acme")
+	ctx = trace.ContextWithSpanContext(ctx, testSpanContext)
+
+	got := correlationFromContext(ctx)
+
+	want := correlation{
+		RequestID: "req-1",
+		TenantID:  "acme",
+		TraceID:   testSpanContext.TraceID().String(),
+		SpanID:    testSpanContext.SpanID().String(),
+		Sampled:   true,
+	}
+	if got != want {
+		t.Errorf("correlationFromContext() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCorrelationFromEmptyContext(t *testing.T) {
+	if got := correlationFromContext(context.Background()); got != (correlation{}) {
+		t.Errorf("correlationFromContext() = %+v, want no identifiers", got)
+	}
+}
+
+func TestCorrelationFollowsActiveSpan(t *testing.T) {
+	ctx := trace.ContextWithSpanContext(context.Background(), testSpanContext)
+	child := testSpanContext.WithSpanID(trace.SpanID{0x01}).WithTraceFlags(0)
+	childCtx := trace.ContextWithSpanContext(ctx, child)
+
+	if got := correlationFromContext(childCtx); got.SpanID != child.SpanID().String() || got.Sampled {
+		t.Errorf("correlationFromContext() = %+v, want the child span %s, not sampled", got, child.SpanID())
+	}
+	if got := correlationFromContext(ctx); got.SpanID != testSpanContext.SpanID().String() {
+		t.Errorf("correlationFromContext() = %+v, want the parent span %s", got, testSpanContext.SpanID())
+	}
+}
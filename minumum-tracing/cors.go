@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// corsAllowedMethods lists the methods of the registered routes, so that
+// browsers let cross-origin pages call each of them.
+const corsAllowedMethods = "GET, POST, PUT, OPTIONS"
+
+// parseAllowedOrigins splits a comma-separated ALLOWED_ORIGINS value,
+// allowing any origin when it is empty.
+func parseAllowedOrigins(v string) []string {
+	origins := parseList(v)
+	if len(origins) == 0 {
+		return []string{"*"}
+	}
+	return origins
+}
+
+// cors sets CORS response headers for allowed origins and answers preflight
+// requests directly with 204, tracing them as a cors-preflight span instead
+// of reaching the route handler.
+func cors(tracer trace.Tracer, allowedOrigins []string) Middleware {
+	allowAny := slices.Contains(allowedOrigins, "*")
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := allowAny || slices.Contains(allowedOrigins, origin)
+			if allowed {
+				if allowAny {
+					w.Header().Set("Access-Control-Allow-Origin", "*")
+				} else {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Add("Vary", "Origin")
+				}
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !preflight {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			_, span := tracer.Start(ctx, "cors-preflight", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				attribute.String("cors.origin", origin),
+				attribute.Bool("cors.allowed", allowed),
+			))
+			defer span.End()
+
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				if h := r.Header.Get("Access-Control-Request-Headers"); h != "" {
+					w.Header().Set("Access-Control-Allow-Headers", h)
+					w.Header().Add("Vary", "Access-Control-Request-Headers")
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
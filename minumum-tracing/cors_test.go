@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://app.example.com")
+	s, exp := newTestServer(t, testConfig(t))
+
+	r := httptest.NewRequest("OPTIONS", "/echo/hi", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	r.Header.Set("Access-Control-Request-Headers", "traceparent")
+	w := serve(s, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": corsAllowedMethods,
+		"Access-Control-Allow-Headers": "traceparent",
+	}
+	for k, v := range want {
+		if got := w.Header().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	findSpan(t, exp, "cors-preflight")
+	for _, span := range exp.GetSpans() {
+		if span.Name == "echo-handler" {
+			t.Error("preflight reached the echo handler")
+		}
+	}
+}
+
+func TestCORSRequest(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://app.example.com")
+	s, _ := newTestServer(t, testConfig(t))
+	tests := []struct {
+		origin string
+		want   string
+	}{
+		{"https://app.example.com", "https://app.example.com"},
+		{"https://evil.example.com", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/echo/hi", nil)
+		r.Header.Set("Origin", tt.origin)
+		w := serve(s, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("origin %s: status = %d, want %d", tt.origin, w.Code, http.StatusOK)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("origin %s: Access-Control-Allow-Origin = %q, want %q", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+
+	if got := serve(s, r).Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
+
+func TestCORSPreflightPut(t *testing.T) {
+	t.Setenv("ALLOWED_ORIGINS", "https://app.example.com")
+	s, _ := newTestServer(t, testConfig(t))
+
+	r := httptest.NewRequest("OPTIONS", "/store/greeting", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	w := serve(s, r)
+
+	allowed := strings.Split(w.Header().Get("Access-Control-Allow-Methods"), ", ")
+	if !slices.Contains(allowed, http.MethodPut) {
+		t.Errorf("Access-Control-Allow-Methods = %q, want PUT for PUT /store/{key}", allowed)
+	}
+}
+
+func TestCORSAllowedMethodsCoverRoutes(t *testing.T) {
+	t.Setenv("ENABLE_DEBUG", "true")
+	s, _ := newTestServer(t, testConfig(t))
+	allowed := strings.Split(corsAllowedMethods, ", ")
+
+	for _, path := range []string{"/echo", "/echo/hi", "/echo/raw", "/echo/batch", "/echo/hi/background", "/store/k", "/admin/shutdown", "/debug/span"} {
+		for _, method := range s.allowedMethods(httptest.NewRequest("GET", path, nil)) {
+			// HEAD is a CORS-safelisted method, allowed without listing it.
+			if method != http.MethodHead && !slices.Contains(allowed, method) {
+				t.Errorf("%s %s is served but missing from Access-Control-Allow-Methods %q", method, path, corsAllowedMethods)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestDeadlineHeader lets callers bound how long they are willing to
+// wait, either as an RFC 3339 timestamp or as a Go duration such as 250ms.
+const requestDeadlineHeader = "X-Request-Deadline"
+
+// parseRequestDeadline returns the deadline described by an
+// X-Request-Deadline value, with durations counted from now.
+func parseRequestDeadline(v string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return time.Time{}, errors.New("invalid " + requestDeadlineHeader + ": must be an RFC 3339 timestamp or a positive duration")
+	}
+	return now.Add(d), nil
+}
+
+// requestDeadline applies the caller's X-Request-Deadline to the handler
+// context and answers 504 if the handler does not finish in time.
+func requestDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get(requestDeadlineHeader)
+		if v == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		deadline, err := parseRequestDeadline(v, time.Now())
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error(), traceIDFromContext(ctx))
+			return
+		}
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(attribute.String("request.deadline", deadline.UTC().Format(time.RFC3339Nano)))
+
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		serveWithin(ctx, w, r, next, func(started bool) {
+			span.AddEvent("deadline_exceeded", trace.WithAttributes(
+				attribute.Bool("response.started", started),
+			))
+			if !started {
+				writeJSONError(w, http.StatusGatewayTimeout, "request deadline exceeded", traceIDFromContext(ctx))
+			}
+		})
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRequestDeadline(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v       string
+		want    time.Time
+		wantErr bool
+	}{
+		{"250ms", now.Add(250 * time.Millisecond), false},
+		{"2024-05-01T12:00:05Z", now.Add(5 * time.Second), false},
+		{"0s", time.Time{}, true},
+		{"-1s", time.Time{}, true},
+		{"soon", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseRequestDeadline(tt.v, now)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseRequestDeadline(%q) = %v, %v, want %v, error %t", tt.v, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	t.Setenv("ECHO_DELAY", "10s")
+	s, exp := newTestServer(t, testConfig(t))
+	tests := []struct {
+		deadline   string
+		wantStatus int
+	}{
+		{"20ms", http.StatusGatewayTimeout},
+		{"soon", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		exp.Reset()
+		r := httptest.NewRequest("GET", "/echo/hi", nil)
+		r.Header.Set(requestDeadlineHeader, tt.deadline)
+
+		if w := serve(s, r); w.Code != tt.wantStatus {
+			t.Errorf("%s %s: status = %d, want %d", requestDeadlineHeader, tt.deadline, w.Code, tt.wantStatus)
+		}
+		if tt.wantStatus != http.StatusGatewayTimeout {
+			continue
+		}
+		span := findSpan(t, exp, "echo-handler")
+		if _, ok := spanAttr(span, "request.deadline"); !ok {
+			t.Error("request.deadline not recorded")
+		}
+	}
+}
+
+func TestRequestDeadlineStreaming(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	req, err := http.NewRequest("GET", srv.URL+"/echo/abc/stream", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(requestDeadlineHeader, "5s")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "abc" {
+		t.Errorf("body = %q, want abc", body)
+	}
+	if resp.Trailer.Get(processingTimeTrailer) == "" {
+		t.Errorf("trailers = %v, want %s", resp.Trailer, processingTimeTrailer)
+	}
+	span := findSpan(t, exp, "echo-stream")
+	if v, _ := spanAttr(span, "stream.flushed"); !v.AsBool() {
+		t.Errorf("%s turned streaming off", requestDeadlineHeader)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DebugSpan is the JSON form of a span captured by the in-memory exporter.
+type DebugSpan struct {
+	Name         string         `json:"name"`
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Kind         string         `json:"kind"`
+	StartTime    time.Time      `json:"start_time"`
+	EndTime      time.Time      `json:"end_time"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Events       []string       `json:"events,omitempty"`
+	Links        []DebugLink    `json:"links,omitempty"`
+	Status       string         `json:"status"`
+}
+
+// DebugLink identifies the span a DebugSpan links to.
+type DebugLink struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+}
+
+func newDebugSpan(stub tracetest.SpanStub) DebugSpan {
+	span := DebugSpan{
+		Name:      stub.Name,
+		TraceID:   stub.SpanContext.TraceID().String(),
+		SpanID:    stub.SpanContext.SpanID().String(),
+		Kind:      stub.SpanKind.String(),
+		StartTime: stub.StartTime,
+		EndTime:   stub.EndTime,
+		Status:    stub.Status.Code.String(),
+	}
+	if stub.Parent.HasSpanID() {
+		span.ParentSpanID = stub.Parent.SpanID().String()
+	}
+	if len(stub.Attributes) > 0 {
+		span.Attributes = make(map[string]any, len(stub.Attributes))
+		for _, kv := range stub.Attributes {
+			span.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+		}
+	}
+	for _, e := range stub.Events {
+		span.Events = append(span.Events, e.Name)
+	}
+	for _, l := range stub.Links {
+		span.Links = append(span.Links, DebugLink{
+			TraceID: l.SpanContext.TraceID().String(),
+			SpanID:  l.SpanContext.SpanID().String(),
+		})
+	}
+	return span
+}
+
+// handleDebugSpans lists the spans captured by the in-memory exporter. It is
+// only registered when IN_MEMORY_SPANS is enabled.
+func (s *server) handleDebugSpans(w http.ResponseWriter, r *http.Request) {
+	stubs := s.spanRecorder.GetSpans()
+	spans := make([]DebugSpan, 0, len(stubs))
+	for _, stub := range stubs {
+		spans = append(spans, newDebugSpan(stub))
+	}
+	s.writeJSON(r.Context(), w, spans)
+}
+
+// DebugTraceNode is a span of a DebugTrace with the spans started under it.
+type DebugTraceNode struct {
+	DebugSpan
+	Children []*DebugTraceNode `json:"children,omitempty"`
+}
+
+// DebugTrace is the JSON form of the spans captured for one trace, nested by
+// parent span ID.
+type DebugTrace struct {
+	TraceID string `json:"trace_id"`
+	// Roots holds the spans whose parent was not captured, usually the
+	// server span and any remote parent's children.
+	Roots []*DebugTraceNode `json:"roots"`
+}
+
+// newDebugTrace nests spans of a single trace under their parents, ordering
+// siblings by start time.
+func newDebugTrace(traceID string, spans []DebugSpan) DebugTrace {
+	slices.SortFunc(spans, func(a, b DebugSpan) int { return a.StartTime.Compare(b.StartTime) })
+	nodes := make(map[string]*DebugTraceNode, len(spans))
+	for _, span := range spans {
+		nodes[span.SpanID] = &DebugTraceNode{DebugSpan: span}
+	}
+	t := DebugTrace{TraceID: traceID, Roots: []*DebugTraceNode{}}
+	for _, span := range spans {
+		node := nodes[span.SpanID]
+		if parent, ok := nodes[span.ParentSpanID]; ok {
+			parent.Children = append(parent.Children, node)
+			continue
+		}
+		t.Roots = append(t.Roots, node)
+	}
+	return t
+}
+
+// handleDebugTrace returns the captured spans of the trace named in the path
+// as a tree. It is only registered when IN_MEMORY_SPANS is enabled.
+func (s *server) handleDebugTrace(w http.ResponseWriter, r *http.Request) {
+	traceID, err := trace.TraceIDFromHex(r.PathValue("traceID"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid trace ID: must be 32 hex characters", traceIDFromContext(r.Context()))
+		return
+	}
+	var spans []DebugSpan
+	for _, stub := range s.spanRecorder.GetSpans() {
+		if stub.SpanContext.TraceID() == traceID {
+			spans = append(spans, newDebugSpan(stub))
+		}
+	}
+	if len(spans) == 0 {
+		writeJSONError(w, http.StatusNotFound, "trace not found", traceIDFromContext(r.Context()))
+		return
+	}
+	s.writeJSON(r.Context(), w, newDebugTrace(traceID.String(), spans))
+}
+
+// handleDebugConfig reports the loaded configuration, with secrets redacted.
+// It is only registered when ENABLE_DEBUG is enabled.
+func (s *server) handleDebugConfig(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(r.Context(), w, s.debugConfig.redacted())
+}
+
+// DebugResource is the JSON form of the resource attached to exported
+// telemetry.
+type DebugResource struct {
+	SchemaURL  string         `json:"schema_url,omitempty"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// handleDebugResource reports the resource attributes detected at startup,
+// such as service.name and the GCP ones. It is only registered when
+// ENABLE_DEBUG is enabled.
+func (s *server) handleDebugResource(w http.ResponseWriter, r *http.Request) {
+	attrs := s.resource.Attributes()
+	res := DebugResource{SchemaURL: s.resource.SchemaURL(), Attributes: make(map[string]any, len(attrs))}
+	for _, kv := range attrs {
+		res.Attributes[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	s.writeJSON(r.Context(), w, res)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DebugSpanRequest is the body of POST /debug/span.
+type DebugSpanRequest struct {
+	Name       string         `json:"name"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// DebugSpanResponse identifies the span created by POST /debug/span.
+type DebugSpanResponse struct {
+	TraceID string `json:"trace_id"`
+	SpanID  string `json:"span_id"`
+}
+
+// handleDebugSpan creates and immediately ends a span with the name and
+// attributes given in the body, for checking a trace pipeline end to end.
+// The span continues the caller's trace when the request carries one. It is
+// only registered when ENABLE_DEBUG is enabled.
+func (s *server) handleDebugSpan(w http.ResponseWriter, r *http.Request) {
+	var req DebugSpanRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, s.maxBodyBytes))
+	dec.UseNumber()
+	if err := dec.Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required", "")
+		return
+	}
+	attrs, err := debugSpanAttributes(req.Attributes)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), "")
+		return
+	}
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := s.tracer.Start(ctx, req.Name, trace.WithAttributes(attrs...))
+	span.End()
+
+	sc := span.SpanContext()
+	slog.InfoContext(ctx, "created debug span", "name", req.Name, "attributes", len(attrs))
+	s.writeJSON(ctx, w, DebugSpanResponse{TraceID: sc.TraceID().String(), SpanID: sc.SpanID().String()})
+}
+
+// debugSpanAttributes converts decoded JSON values to span attributes,
+// accepting only strings, numbers and booleans. Numbers without a fraction
+// become integer attributes.
+func debugSpanAttributes(m map[string]any) ([]attribute.KeyValue, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute.KeyValue, 0, len(m))
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case string:
+			attrs = append(attrs, attribute.String(k, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(k, v))
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				attrs = append(attrs, attribute.Int64(k, n))
+			} else if f, err := v.Float64(); err == nil {
+				attrs = append(attrs, attribute.Float64(k, f))
+			} else {
+				return nil, fmt.Errorf("attribute %q: number out of range", k)
+			}
+		default:
+			return nil, fmt.Errorf("attribute %q: value must be a string, number or boolean", k)
+		}
+	}
+	return attrs, nil
+}
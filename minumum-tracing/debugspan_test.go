@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestDebugSpan(t *testing.T) {
+	t.Setenv("ENABLE_DEBUG", "true")
+	s, exp := newTestServer(t, testConfig(t))
+
+	body := `{"name":"pipeline-check","attributes":{"env":"staging","retries":3,"ratio":0.5,"canary":true}}`
+	w := serve(s, httptest.NewRequest("POST", "/debug/span", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+	var resp DebugSpanResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	span := findSpan(t, exp, "pipeline-check")
+	if resp.TraceID != span.SpanContext.TraceID().String() || resp.SpanID != span.SpanContext.SpanID().String() {
+		t.Errorf("response = %+v, want the IDs of the created span", resp)
+	}
+	want := map[attribute.Key]attribute.Value{
+		"env":     attribute.StringValue("staging"),
+		"retries": attribute.Int64Value(3),
+		"ratio":   attribute.Float64Value(0.5),
+		"canary":  attribute.BoolValue(true),
+	}
+	for key, value := range want {
+		if got, _ := spanAttr(span, key); got != value {
+			t.Errorf("%s = %v (%s), want %v (%s)", key, got.Emit(), got.Type(), value.Emit(), value.Type())
+		}
+	}
+}
+
+func TestDebugSpanInvalid(t *testing.T) {
+	t.Setenv("ENABLE_DEBUG", "true")
+	s, exp := newTestServer(t, testConfig(t))
+
+	for _, body := range []string{
+		`{"attributes":{"env":"staging"}}`,
+		`{"name":"bad","attributes":{"nested":{"a":1}}}`,
+		`{"name":"bad","attributes":{"list":[1,2]}}`,
+		`{"name":"bad","attributes":{"missing":null}}`,
+	} {
+		w := serve(s, httptest.NewRequest("POST", "/debug/span", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("POST %s status = %d, want %d", body, w.Code, http.StatusBadRequest)
+		}
+	}
+	for _, span := range exp.GetSpans() {
+		if span.Name == "bad" {
+			t.Error("created a span for an invalid request")
+		}
+	}
+}
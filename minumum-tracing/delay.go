@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// delayRange picks the artificial echo delay uniformly between min and max.
+// With min equal to max the delay is fixed.
+type delayRange struct {
+	min, max time.Duration
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newDelayRange returns a delayRange whose random sequence is determined by
+// seed, so that a demo can be replayed with the same delays.
+func newDelayRange(minDelay, maxDelay time.Duration, seed uint64) *delayRange {
+	return &delayRange{min: minDelay, max: maxDelay, rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+func (d *delayRange) next() time.Duration {
+	if d.max <= d.min {
+		return d.min
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.min + time.Duration(d.rng.Int64N(int64(d.max-d.min)+1))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDelayRange(t *testing.T) {
+	d := newDelayRange(time.Millisecond, 5*time.Millisecond, 42)
+	var delays []time.Duration
+	for range 100 {
+		delay := d.next()
+		if delay < time.Millisecond || delay > 5*time.Millisecond {
+			t.Fatalf("delay %s outside [1ms, 5ms]", delay)
+		}
+		delays = append(delays, delay)
+	}
+
+	replay := newDelayRange(time.Millisecond, 5*time.Millisecond, 42)
+	for i, want := range delays {
+		if got := replay.next(); got != want {
+			t.Fatalf("delay %d with the same seed = %s, want %s", i, got, want)
+		}
+	}
+
+	if slices.Min(delays) == slices.Max(delays) {
+		t.Errorf("delays never vary: %s", delays[0])
+	}
+}
+
+func TestDelayRangeFixed(t *testing.T) {
+	d := newDelayRange(3*time.Millisecond, 3*time.Millisecond, 0)
+	if got := d.next(); got != 3*time.Millisecond {
+		t.Errorf("next() = %s, want 3ms", got)
+	}
+}
+
+func TestEchoDelayAttribute(t *testing.T) {
+	t.Setenv("ECHO_DELAY_MIN", "1ms")
+	t.Setenv("ECHO_DELAY_MAX", "3ms")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	v, _ := spanAttr(findSpan(t, exp, "echo-handler"), "echo.delay")
+	delay, err := time.ParseDuration(v.AsString())
+	if err != nil || delay < time.Millisecond || delay > 3*time.Millisecond {
+		t.Errorf("echo.delay = %q, want a delay within [1ms, 3ms]", v.AsString())
+	}
+}
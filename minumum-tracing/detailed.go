@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// namedMiddleware is a middleware with the name its span gets when
+// DETAILED_SPANS is enabled.
+type namedMiddleware struct {
+	name string
+	mw   Middleware
+}
+
+// spanAround times h in a span named after it. The span is a child of the
+// request span but deliberately not made the active one, so that middlewares
+// and handlers keep annotating the request span. Since each middleware's
+// span covers those inside it, its own cost is the gap to the next span in
+// the waterfall.
+func spanAround(tracer trace.Tracer, name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracer.Start(r.Context(), name)
+		defer span.End()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// detailed wraps each middleware in a "middleware <name>" span and next in a
+// "handler" span.
+func detailed(tracer trace.Tracer, mws []namedMiddleware) []Middleware {
+	out := make([]Middleware, 0, len(mws)+1)
+	for _, m := range mws {
+		out = append(out, func(next http.Handler) http.Handler {
+			return spanAround(tracer, "middleware "+m.name, m.mw(next))
+		})
+	}
+	return append(out, func(next http.Handler) http.Handler {
+		return spanAround(tracer, "handler", next)
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDetailedSpans(t *testing.T) {
+	t.Setenv("DETAILED_SPANS", "true")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	request := findSpan(t, exp, "echo-handler")
+	outer := findSpan(t, exp, "middleware traceparent")
+	inner := findSpan(t, exp, "middleware recovery")
+	handler := findSpan(t, exp, "handler")
+	// The spans are siblings under the request span, nested in time only, so
+	// that the request span stays the active one.
+	for _, span := range []tracetest.SpanStub{outer, inner, handler} {
+		if span.Parent.SpanID() != request.SpanContext.SpanID() {
+			t.Errorf("%s span parent = %s, want the request span %s", span.Name, span.Parent.SpanID(), request.SpanContext.SpanID())
+		}
+	}
+	if outer.StartTime.After(inner.StartTime) || inner.StartTime.After(handler.StartTime) ||
+		handler.EndTime.After(inner.EndTime) || inner.EndTime.After(outer.EndTime) {
+		t.Error("middleware spans do not cover the spans inside them")
+	}
+	for _, name := range []string{"middleware tenant", "middleware compress", "middleware timeout"} {
+		findSpan(t, exp, name)
+	}
+}
+
+func TestDetailedSpansDisabled(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	for _, span := range exp.GetSpans() {
+		if strings.HasPrefix(span.Name, "middleware ") || span.Name == "handler" {
+			t.Errorf("recorded %q span, want none without DETAILED_SPANS", span.Name)
+		}
+	}
+}
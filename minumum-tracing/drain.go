@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// drainPollInterval is how often drainInflight checks the in-flight
+	// requests.
+	drainPollInterval = 50 * time.Millisecond
+	// drainLogInterval is how often drainInflight reports its progress.
+	drainLogInterval = time.Second
+)
+
+// shutdown stops httpServer with App Engine drain semantics: readiness is
+// cleared first, so that health checks stop routing new requests here, while
+// the server keeps serving for DrainDelay and, with DrainTimeout, until the
+// in-flight requests are done. Only then does the graceful Shutdown begin,
+// bounded by ShutdownTimeout.
+func (s *server) shutdown(httpServer *http.Server, cfg Config, reason string) error {
+	s.startDraining()
+	slog.Info("readiness cleared, draining", "reason", reason)
+	if cfg.DrainDelay > 0 {
+		// Keep serving while the load balancer notices the failing
+		// readiness check and stops routing new requests here.
+		slog.Info("waiting for load balancer deregistration", "drain_delay", cfg.DrainDelay.String())
+		time.Sleep(cfg.DrainDelay)
+		slog.Info("drain delay elapsed")
+	}
+	if cfg.DrainTimeout > 0 {
+		s.drainInflight(cfg.DrainTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	slog.Info("shutting down server", "reason", reason, "timeout_seconds", cfg.ShutdownTimeout.Seconds())
+	start := time.Now()
+	err := httpServer.Shutdown(ctx)
+	if err != nil {
+		slog.Error("server shutdown did not complete within timeout", "error", err, "elapsed", time.Since(start).String())
+	} else {
+		slog.Info("server shutdown completed", "elapsed", time.Since(start).String())
+	}
+	return err
+}
+
+// countInflight keeps n at the number of requests being handled.
+func countInflight(n *atomic.Int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n.Add(1)
+			defer n.Add(-1)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// trackConnection keeps s.connections at the number of open connections. It
+// is installed as the http.Server ConnState hook.
+func (s *server) trackConnection(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.connections.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		s.connections.Add(-1)
+	}
+}
+
+// drainInflight keeps serving until no request is in flight or timeout
+// elapses, logging the remaining requests along the way. Unlike a fixed
+// delay, it stops waiting as soon as the slow requests are done, and does not
+// cut them off while they finish within timeout.
+func (s *server) drainInflight(timeout time.Duration) {
+	start := time.Now()
+	deadline := start.Add(timeout)
+	// Report the requests being waited for right away.
+	lastLog := start.Add(-drainLogInterval)
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		n := s.inflight.Load()
+		if n == 0 {
+			slog.Info("no requests in flight", "elapsed", time.Since(start).String())
+			return
+		}
+		now := time.Now()
+		if !now.Before(deadline) {
+			slog.Warn("drain timeout elapsed with requests still in flight",
+				append(s.drainAttrs(n), "drain_timeout", timeout.String())...)
+			return
+		}
+		if now.Sub(lastLog) >= drainLogInterval {
+			slog.Info("draining in-flight requests",
+				append(s.drainAttrs(n), "remaining", deadline.Sub(now).Round(time.Millisecond).String())...)
+			lastLog = now
+		}
+		<-ticker.C
+	}
+}
+
+// drainAttrs returns the counters logged while draining.
+func (s *server) drainAttrs(inflight int64) []any {
+	attrs := []any{
+		"inflight", inflight,
+		"open_connections", s.connections.Load(),
+	}
+	if s.loadShedder != nil {
+		attrs = append(attrs, "load_shed_inflight", s.loadShedder.inflight.Load())
+	}
+	return attrs
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestShutdownClearsReadinessFirst(t *testing.T) {
+	t.Setenv("DRAIN_DELAY", "200ms")
+	cfg := testConfig(t)
+	s, _ := newTestServer(t, cfg)
+	s.ready.Store(true)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := &http.Server{Handler: s}
+	go httpServer.Serve(ln)
+	url := "http://" + ln.Addr().String()
+	resp, err := http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("readiness before the shutdown = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.shutdown(httpServer, cfg, "test") }()
+
+	// The server keeps serving during the drain delay, but reports that it
+	// is no longer ready.
+	time.Sleep(50 * time.Millisecond)
+	resp, err = http.Get(url + "/healthz")
+	if err != nil {
+		t.Fatalf("readiness during the drain: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("readiness during the drain = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	select {
+	case <-done:
+		t.Fatal("shutdown finished before the drain delay")
+	default:
+	}
+
+	if err := <-done; err != nil {
+		t.Errorf("shutdown() error = %v", err)
+	}
+	if _, err := http.Get(url + "/healthz"); err == nil {
+		t.Error("server still accepts requests after the shutdown")
+	}
+}
+
+func TestShutdownWaitsDrainDelay(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	t.Setenv("DRAIN_DELAY", delay.String())
+	cfg := testConfig(t)
+	s, _ := newTestServer(t, cfg)
+	logs := captureLogs(t)
+
+	if err := s.shutdown(&http.Server{}, cfg, "test"); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	var phases []string
+	times := map[string]time.Time{}
+	for _, entry := range logs() {
+		msg := entry["msg"].(string)
+		phases = append(phases, msg)
+		times[msg], _ = time.Parse(time.RFC3339Nano, entry["time"].(string))
+	}
+	want := []string{
+		"readiness cleared, draining",
+		"waiting for load balancer deregistration",
+		"drain delay elapsed",
+		"shutting down server",
+		"server shutdown completed",
+	}
+	if !slices.Equal(phases, want) {
+		t.Fatalf("phases = %q, want %q", phases, want)
+	}
+	if waited := times["shutting down server"].Sub(times["readiness cleared, draining"]); waited < delay {
+		t.Errorf("shutdown began %s after readiness was cleared, want at least %s", waited, delay)
+	}
+}
+
+func TestShutdownWaitsForInflightRequests(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	t.Setenv("DRAIN_TIMEOUT", "5s")
+	t.Setenv("ECHO_DELAY", delay.String())
+	cfg := testConfig(t)
+	s, _ := newTestServer(t, cfg)
+	logs := captureLogs(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer := &http.Server{Handler: s, ConnState: s.trackConnection}
+	go httpServer.Serve(ln)
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/echo/hi")
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+	for s.inflight.Load() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := s.shutdown(httpServer, cfg, "test"); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("slow request status = %d, want %d", code, http.StatusOK)
+	}
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("shutdown took %s, want it to stop waiting once the request was done", elapsed)
+	}
+	entries := logs()
+	progress := findLog(t, entries, "draining in-flight requests")
+	if progress["inflight"] != 1.0 || progress["open_connections"] != 1.0 {
+		t.Errorf("drain progress = %v, want 1 request on 1 connection", progress)
+	}
+	findLog(t, entries, "no requests in flight")
+	if i, j := slices.IndexFunc(entries, logMsg("no requests in flight")), slices.IndexFunc(entries, logMsg("shutting down server")); i > j {
+		t.Error("server shut down before the in-flight request was done")
+	}
+}
+
+func TestDrainInflightTimeout(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	logs := captureLogs(t)
+	s.inflight.Add(1)
+
+	start := time.Now()
+	s.drainInflight(100 * time.Millisecond)
+
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed >= time.Second {
+		t.Errorf("drain took %s, want it to give up after the 100ms timeout", elapsed)
+	}
+	if entry := findLog(t, logs(), "drain timeout elapsed with requests still in flight"); entry["inflight"] != 1.0 {
+		t.Errorf("inflight = %v, want 1", entry["inflight"])
+	}
+}
+
+// logMsg matches log entries with msg.
+func logMsg(msg string) func(map[string]any) bool {
+	return func(entry map[string]any) bool { return entry["msg"] == msg }
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// enrichLatency is how long the simulated downstream call takes.
+const enrichLatency = 20 * time.Millisecond
+
+var errDownstreamFailed = errors.New("downstream enrichment failed")
+
+// EnrichedResponse is the response of GET /echo/{message}/enriched.
+type EnrichedResponse struct {
+	Message  string `json:"message"`
+	Enriched string `json:"enriched"`
+}
+
+// handleEchoEnriched echoes the message together with the result of a
+// simulated downstream call, which is guarded by a circuit breaker.
+func (s *server) handleEchoEnriched(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	message := r.PathValue("message")
+	if !s.checkMessageLength(w, r, message) {
+		return
+	}
+	span.SetAttributes(s.messageAttributes(message)...)
+
+	if !s.breaker.allow(ctx) {
+		span.SetStatus(codes.Error, "circuit breaker open")
+		writeJSONError(w, http.StatusServiceUnavailable, "downstream unavailable: circuit breaker open", traceIDFromContext(ctx))
+		return
+	}
+	enriched, err := s.enrich(ctx, message)
+	if errors.Is(err, context.Canceled) {
+		// The client went away, which says nothing about the dependency.
+		s.breaker.release()
+		span.AddEvent("client_disconnected")
+		return
+	}
+	s.breaker.record(ctx, err)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		writeJSONError(w, http.StatusBadGateway, err.Error(), traceIDFromContext(ctx))
+		return
+	}
+
+	s.echoCount.Add(ctx, 1)
+	s.writeJSON(ctx, w, s.withResponseField(EnrichedResponse{Message: message, Enriched: enriched}))
+}
+
+// enrich simulates a call to a downstream service that fails with
+// probability enrichFailureRate.
+func (s *server) enrich(ctx context.Context, message string) (string, error) {
+	ctx, span := s.tracer.Start(ctx, "enrich-downstream", trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	select {
+	case <-ctx.Done():
+		span.RecordError(ctx.Err())
+		span.SetStatus(codes.Error, ctx.Err().Error())
+		return "", ctx.Err()
+	case <-time.After(enrichLatency):
+	}
+
+	if rand.Float64() < s.enrichFailureRate {
+		span.RecordError(errDownstreamFailed)
+		span.SetStatus(codes.Error, errDownstreamFailed.Error())
+		return "", errDownstreamFailed
+	}
+	return strings.ToUpper(message), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// firstEnv returns the value of the first non-empty environment variable.
+func firstEnv(keys ...string) string {
+	for _, key := range keys {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// durationEnv parses the environment variable key as a non-negative Go
+// duration, returning def when it is unset or invalid.
+func durationEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return def, fmt.Errorf("invalid %s %q: must be a non-negative duration", key, v)
+	}
+	return d, nil
+}
+
+// intEnv parses the environment variable key as a non-negative integer,
+// returning def when it is unset or invalid.
+func intEnv(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return def, fmt.Errorf("invalid %s %q: must be a non-negative integer", key, v)
+	}
+	return n, nil
+}
+
+// millisecondsEnv parses the environment variable key as a non-negative
+// number of milliseconds, returning def when it is unset or invalid.
+func millisecondsEnv(key string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return def, fmt.Errorf("invalid %s %q: must be a non-negative number of milliseconds", key, v)
+	}
+	return time.Duration(n) * time.Millisecond, nil
+}
+
+// floatEnv parses the environment variable key as a non-negative number,
+// returning def when it is unset or invalid.
+func floatEnv(key string, def float64) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return def, fmt.Errorf("invalid %s %q: must be a non-negative number", key, v)
+	}
+	return f, nil
+}
+
+// boolEnv parses the environment variable key as a boolean, returning false
+// when it is unset or invalid.
+func boolEnv(key string) (bool, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be a boolean", key, v)
+	}
+	return b, nil
+}
+
+// levelEnv parses the environment variable key as a log level name such as
+// debug or warn, returning def when it is unset or invalid.
+func levelEnv(key string, def slog.Level) (slog.Level, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(v)); err != nil {
+		return def, fmt.Errorf("invalid %s %q: must be debug, info, warn or error", key, v)
+	}
+	return l, nil
+}
+
+// parseList splits a comma-separated list, ignoring blank entries. It returns
+// nil when v holds no entries.
+func parseList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		ifNoneMatch string
+		want        bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{"*", true},
+		{`"xyz"`, false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, etag); got != tt.want {
+			t.Errorf("etagMatches(%q, %q) = %t, want %t", tt.ifNoneMatch, etag, got, tt.want)
+		}
+	}
+}
+
+func TestEchoConditionalRequest(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	first := serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+	etag := first.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag on the echo response")
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{etag, http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		exp.Reset()
+		r := httptest.NewRequest("GET", "/echo/hi", nil)
+		r.Header.Set("If-None-Match", tt.ifNoneMatch)
+
+		w := serve(s, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("If-None-Match %s: status = %d, want %d", tt.ifNoneMatch, w.Code, tt.wantStatus)
+		}
+		notModified, _ := spanAttr(findSpan(t, exp, "echo-handler"), "http.response.not_modified")
+		if notModified.AsBool() != (tt.wantStatus == http.StatusNotModified) {
+			t.Errorf("If-None-Match %s: http.response.not_modified = %t", tt.ifNoneMatch, notModified.AsBool())
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("Accept", "text/plain")
+	if got := serve(s, r).Header().Get("ETag"); got == etag {
+		t.Error("text/plain response shares the JSON ETag")
+	}
+}
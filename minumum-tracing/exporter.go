@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	texporter "github.com/GoogleCloudPlatform/opentelemetry-operations-go/exporter/trace"
+	"go.opentelemetry.io/contrib/exporters/autoexport"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/oauth"
+)
+
+// otlpTarget is the OTLP trace destination resolved from the standard
+// OTEL_EXPORTER_OTLP_* environment variables.
+type otlpTarget struct {
+	protocol string
+	endpoint string
+	insecure bool
+}
+
+func otlpTargetFromEnv() otlpTarget {
+	t := otlpTarget{
+		protocol: firstEnv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL"),
+		endpoint: firstEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"),
+	}
+	if t.protocol == "" {
+		t.protocol = "http/protobuf"
+	}
+	insecure := firstEnv("OTEL_EXPORTER_OTLP_TRACES_INSECURE", "OTEL_EXPORTER_OTLP_INSECURE")
+	t.insecure = strings.EqualFold(insecure, "true") || strings.HasPrefix(t.endpoint, "http://")
+	return t
+}
+
+// hostPort returns the address the exporter connects to, applying the OTLP
+// default ports when the endpoint omits one.
+func (t otlpTarget) hostPort() string {
+	host, port := "localhost", "4318"
+	if t.protocol == "grpc" {
+		port = "4317"
+	}
+
+	endpoint := t.endpoint
+	if strings.Contains(endpoint, "://") {
+		if u, err := url.Parse(endpoint); err == nil {
+			endpoint = u.Host
+			if u.Port() == "" && u.Scheme == "https" {
+				port = "443"
+			}
+		}
+	}
+	if endpoint != "" {
+		if h, p, err := net.SplitHostPort(endpoint); err == nil {
+			host, port = h, p
+		} else {
+			host = endpoint
+		}
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// isGoogleCloud reports whether the target is Google's own OTLP endpoint, such
+// as telemetry.googleapis.com, which requires TLS and Google credentials.
+func (t otlpTarget) isGoogleCloud() bool {
+	host, _, _ := net.SplitHostPort(t.hostPort())
+	return strings.HasSuffix(host, ".googleapis.com")
+}
+
+// newSpanExporter creates the configured span exporter. OTLP over gRPC to a
+// Google Cloud endpoint is authenticated with Application Default
+// Credentials; other exporters are delegated to autoexport.
+func newSpanExporter(ctx context.Context, cfg Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "console":
+		return stdouttrace.New()
+	case "file":
+		return newFileExporter(cfg.SpanFile)
+	case "cloudtrace":
+		return texporter.New(texporter.WithProjectID(cfg.ProjectID))
+	}
+
+	t := otlpTargetFromEnv()
+	if cfg.Exporter != "otlp" || !t.isGoogleCloud() {
+		return autoexport.NewSpanExporter(ctx)
+	}
+
+	if t.insecure {
+		return nil, fmt.Errorf("OTLP endpoint %s requires TLS but insecure was requested", t.hostPort())
+	}
+	if t.protocol != "grpc" {
+		slog.Warn("Google Cloud OTLP endpoint needs credentials which are only attached for the grpc protocol",
+			"protocol", t.protocol,
+			"endpoint", t.hostPort(),
+		)
+		return autoexport.NewSpanExporter(ctx)
+	}
+
+	creds, err := oauth.NewApplicationDefault(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, fmt.Errorf("load Google credentials: %w", err)
+	}
+	return otlptracegrpc.New(ctx, otlptracegrpc.WithDialOption(grpc.WithPerRPCCredentials(creds)))
+}
+
+// exporterName resolves which span exporter to use from the environment.
+// TRACE_TO_STDOUT forces the console exporter, SPAN_FILE writes spans to that
+// file, and USE_CLOUD_TRACE selects the Cloud Trace exporter when
+// GOOGLE_CLOUD_PROJECT is set. Then OTEL_TRACES_EXPORTER applies as usual.
+// With neither an exporter nor an OTLP endpoint configured, spans are printed
+// to stdout so that running locally always shows them, instead of silently
+// failing to reach a collector that is not there.
+func exporterName() (string, error) {
+	toStdout, err := boolEnv("TRACE_TO_STDOUT")
+	if err != nil {
+		return "", err
+	}
+	if toStdout {
+		return "console", nil
+	}
+	if os.Getenv("SPAN_FILE") != "" {
+		return "file", nil
+	}
+	useCloudTrace, err := boolEnv("USE_CLOUD_TRACE")
+	if err != nil {
+		return "", err
+	}
+	if useCloudTrace && os.Getenv("GOOGLE_CLOUD_PROJECT") != "" {
+		return "cloudtrace", nil
+	}
+	if name := os.Getenv("OTEL_TRACES_EXPORTER"); name != "" {
+		return name, nil
+	}
+	if otlpTargetFromEnv().endpoint != "" {
+		return "otlp", nil
+	}
+	return "console", nil
+}
+
+// probeOTLPEndpoint checks that the OTLP endpoint accepts TCP connections so
+// that a misconfiguration is reported at startup instead of on first export.
+func probeOTLPEndpoint(ctx context.Context, t otlpTarget) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.hostPort())
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// logSpanExporter reports the exporter in use, since autoexport is otherwise
+// silent about where spans end up.
+func logSpanExporter(ctx context.Context, exp sdktrace.SpanExporter, cfg Config) {
+	name := cfg.Exporter
+	if cfg.UseCloudTrace && name != "cloudtrace" {
+		slog.Warn("USE_CLOUD_TRACE is set but GOOGLE_CLOUD_PROJECT is not, falling back to the standard exporter selection")
+	}
+	if autoexport.IsNoneSpanExporter(exp) {
+		slog.Warn("span exporter is disabled, spans will not be exported", "exporter", name)
+		return
+	}
+
+	attrs := []any{"exporter", name, "type", fmt.Sprintf("%T", exp)}
+	if name == "cloudtrace" {
+		attrs = append(attrs, "project_id", cfg.ProjectID)
+	}
+	if name == "file" {
+		attrs = append(attrs, "path", cfg.SpanFile)
+	}
+	if name == "otlp" {
+		t := otlpTargetFromEnv()
+		attrs = append(attrs,
+			"protocol", t.protocol,
+			"endpoint", t.hostPort(),
+			"tls", !t.insecure,
+		)
+
+		if err := probeOTLPEndpoint(ctx, t); err != nil {
+			slog.Error("OTLP endpoint is unreachable, spans will fail to export", "endpoint", t.hostPort(), "error", err)
+		}
+	}
+	if name == "console" && os.Getenv("OTEL_TRACES_EXPORTER") == "" {
+		slog.Warn("no span exporter configured, printing spans to stdout; set OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_TRACES_EXPORTER to export them")
+	}
+	slog.Info("span exporter configured", attrs...)
+}
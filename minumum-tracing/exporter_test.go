@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestExporterName(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{"nothing configured", nil, "console"},
+		{"cloud trace", map[string]string{"USE_CLOUD_TRACE": "true", "GOOGLE_CLOUD_PROJECT": "my-project"}, "cloudtrace"},
+		{"cloud trace without project", map[string]string{"USE_CLOUD_TRACE": "true", "OTEL_TRACES_EXPORTER": "otlp"}, "otlp"},
+		{"cloud trace over OTEL_TRACES_EXPORTER", map[string]string{
+			"USE_CLOUD_TRACE":      "true",
+			"GOOGLE_CLOUD_PROJECT": "my-project",
+			"OTEL_TRACES_EXPORTER": "otlp",
+		}, "cloudtrace"},
+		{"OTEL_TRACES_EXPORTER", map[string]string{"OTEL_TRACES_EXPORTER": "none"}, "none"},
+		{"OTLP endpoint", map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "https://collector:4318"}, "otlp"},
+		{"stdout", map[string]string{"TRACE_TO_STDOUT": "true", "USE_CLOUD_TRACE": "true", "GOOGLE_CLOUD_PROJECT": "p"}, "console"},
+		{"span file", map[string]string{"SPAN_FILE": "spans.jsonl", "OTEL_TRACES_EXPORTER": "otlp"}, "file"},
+	}
+	keys := []string{
+		"TRACE_TO_STDOUT", "SPAN_FILE", "USE_CLOUD_TRACE", "GOOGLE_CLOUD_PROJECT", "OTEL_TRACES_EXPORTER",
+		"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range keys {
+				t.Setenv(k, tt.env[k])
+			}
+
+			got, err := exporterName()
+			if err != nil {
+				t.Fatalf("exporterName() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("exporterName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExporterNameInvalid(t *testing.T) {
+	t.Setenv("TRACE_TO_STDOUT", "")
+	t.Setenv("SPAN_FILE", "")
+	t.Setenv("USE_CLOUD_TRACE", "maybe")
+
+	if _, err := exporterName(); err == nil {
+		t.Error("exporterName() accepted USE_CLOUD_TRACE=maybe")
+	}
+}
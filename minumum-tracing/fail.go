@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handleEchoFail deliberately fails with the status given in the status query
+// parameter, 500 by default, so that error reporting in traces, logs and
+// alerts can be verified end to end.
+func (s *server) handleEchoFail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	status := http.StatusInternalServerError
+	if v := r.URL.Query().Get("status"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 400 || n > 599 {
+			writeJSONError(w, http.StatusBadRequest, "status must be an HTTP error code between 400 and 599", traceIDFromContext(ctx))
+			return
+		}
+		status = n
+	}
+
+	message := r.PathValue("message")
+	if !s.checkMessageLength(w, r, message) {
+		return
+	}
+	err := fmt.Errorf("injected failure for message %q", message)
+	span.SetAttributes(attribute.Int("fail.status_code", status))
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	slog.ErrorContext(ctx, "injected failure", "status", status, "error", err)
+
+	writeJSONError(w, status, err.Error(), traceIDFromContext(ctx))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// compressedAttr returns the http.response.compressed attribute recorded on
+// any of the spans in exp.
+func compressedAttr(t *testing.T, exp *tracetest.InMemoryExporter) bool {
+	t.Helper()
+	for _, span := range exp.GetSpans() {
+		if v, ok := spanAttr(span, "http.response.compressed"); ok {
+			return v.AsBool()
+		}
+	}
+	t.Fatal("http.response.compressed not recorded")
+	return false
+}
+
+func TestCompress(t *testing.T) {
+	t.Setenv("GZIP_MIN_BYTES", "64")
+	s, exp := newTestServer(t, testConfig(t))
+	message := strings.Repeat("a", 200)
+
+	r := httptest.NewRequest("GET", "/echo/"+message, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := serve(s, r)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp Response
+	if err := json.NewDecoder(gz).Decode(&resp); err != nil || resp.Message != message {
+		t.Errorf("decompressed response = %+v (%v), want the echoed message", resp, err)
+	}
+	if !compressedAttr(t, exp) {
+		t.Error("http.response.compressed = false, want true")
+	}
+}
+
+func TestCompressSmallResponse(t *testing.T) {
+	t.Setenv("GZIP_MIN_BYTES", "1024")
+	s, exp := newTestServer(t, testConfig(t))
+
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := serve(s, r)
+
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none below GZIP_MIN_BYTES", got)
+	}
+	var resp Response
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Message != "hi" {
+		t.Errorf("response = %+v (%v), want the echoed message", resp, err)
+	}
+	if compressedAttr(t, exp) {
+		t.Error("http.response.compressed = true, want false")
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"*, gzip;q=0", false},
+		{"br", false},
+	}
+	for _, tt := range tests {
+		if got := acceptsGzip(tt.acceptEncoding); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
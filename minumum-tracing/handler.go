@@ -0,0 +1,20 @@
+package main
+
+import "net/http"
+
+// Handler is an endpoint served at Pattern, a net/http.ServeMux pattern such
+// as "GET /echo". Handlers passed to newServer are registered next to the
+// built-in ones, wrapped in the server middlewares; wrap them in tracing to
+// have them traced.
+type Handler interface {
+	Pattern() string
+	http.Handler
+}
+
+// endpoint pairs a pattern with the handler serving it.
+type endpoint struct {
+	pattern string
+	http.Handler
+}
+
+func (e endpoint) Pattern() string { return e.pattern }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// pingHandler is a custom endpoint, as users of the sample would add.
+type pingHandler struct{}
+
+func (pingHandler) Pattern() string { return "GET /ping" }
+
+func (pingHandler) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Write([]byte("pong"))
+}
+
+func TestCustomHandler(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t), pingHandler{})
+
+	w := serve(s, httptest.NewRequest("GET", "/ping", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Errorf("GET /ping = %d %q, want 200 pong", w.Code, w.Body.String())
+	}
+	if w.Header().Get(requestIDHeader) == "" {
+		t.Errorf("%s not set, want the global middlewares applied to custom handlers", requestIDHeader)
+	}
+	if w := serve(s, httptest.NewRequest("POST", "/ping", nil)); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /ping status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
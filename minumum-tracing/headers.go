@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// builtinDefaultHeaders returns the headers hardening every response unless
+// DEFAULT_HEADERS says otherwise.
+func builtinDefaultHeaders() http.Header {
+	return http.Header{
+		"X-Content-Type-Options": {"nosniff"},
+		"X-Frame-Options":        {"DENY"},
+		"Referrer-Policy":        {"no-referrer"},
+	}
+}
+
+// parseDefaultHeaders parses a comma-separated list of Name=value pairs,
+// such as DEFAULT_HEADERS="X-Frame-Options=SAMEORIGIN,Server=echo", whose
+// values therefore cannot contain commas. The pairs are added to the
+// built-in headers, overriding those of the same name; an empty value, as in
+// "Referrer-Policy=", removes one.
+func parseDefaultHeaders(v string) (http.Header, error) {
+	h := builtinDefaultHeaders()
+	for _, item := range parseList(v) {
+		name, value, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return builtinDefaultHeaders(), fmt.Errorf("invalid DEFAULT_HEADERS entry %q: must be Name=value", item)
+		}
+		if value = strings.TrimSpace(value); value == "" {
+			h.Del(name)
+			continue
+		}
+		h.Set(name, value)
+	}
+	return h, nil
+}
+
+// defaultHeaders sets headers on every response before the handler runs, so
+// that a handler setting the same header still wins.
+func defaultHeaders(headers http.Header) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(headers) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, values := range headers {
+				w.Header()[name] = values
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
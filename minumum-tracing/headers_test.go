@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDefaultHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		env  string
+		want map[string]string
+	}{
+		{"built-in", "", map[string]string{
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "DENY",
+			"Referrer-Policy":        "no-referrer",
+		}},
+		{"extended and overridden", "X-Frame-Options=SAMEORIGIN, Server=echo, Referrer-Policy=", map[string]string{
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "SAMEORIGIN",
+			"Server":                 "echo",
+			"Referrer-Policy":        "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("DEFAULT_HEADERS", tt.env)
+			s, _ := newTestServer(t, testConfig(t))
+
+			for _, target := range []string{"/echo/hi", defaultHealthPath} {
+				w := serve(s, httptest.NewRequest("GET", target, nil))
+				for name, want := range tt.want {
+					if got := w.Header().Get(name); got != want {
+						t.Errorf("GET %s: %s = %q, want %q", target, name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestParseDefaultHeadersInvalid(t *testing.T) {
+	h, err := parseDefaultHeaders("Server=echo,nonsense")
+	if err == nil {
+		t.Fatal("parseDefaultHeaders() accepted an entry without =")
+	}
+	if want := builtinDefaultHeaders(); len(h) != len(want) || h.Get("Server") != "" {
+		t.Errorf("headers = %v, want the built-in %v", h, want)
+	}
+}
+
+func TestDefaultHeadersHandlerWins(t *testing.T) {
+	h := defaultHeaders(builtinDefaultHeaders())(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+	}))
+
+	if got := serve(h, httptest.NewRequest("GET", "/", nil)).Header().Get("X-Frame-Options"); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options = %q, want the handler's SAMEORIGIN", got)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// degradedAfterExportFailures is how many consecutive failed exports mark
+// the service as degraded.
+const degradedAfterExportFailures = 3
+
+// exportFailureWarnInterval is the minimum time between two warnings about
+// failing span exports, so that an unreachable collector does not flood the
+// logs.
+const exportFailureWarnInterval = time.Minute
+
+// HealthResponse is the response of the health check.
+type HealthResponse struct {
+	Status                    string `json:"status"`
+	Degraded                  bool   `json:"degraded"`
+	ConsecutiveExportFailures int64  `json:"consecutive_export_failures,omitempty"`
+	LastExportError           string `json:"last_export_error,omitempty"`
+}
+
+// exportHealth tracks whether span exports have recently been failing. A nil
+// exportHealth is never degraded.
+type exportHealth struct {
+	failures atomic.Int64
+	lastErr  atomic.Pointer[string]
+	// lastWarn is when failing exports were last logged, in Unix nanoseconds.
+	lastWarn atomic.Int64
+
+	// queued and dropped are maintained by queueMonitor.
+	queued  atomic.Int64
+	dropped atomic.Int64
+}
+
+func (h *exportHealth) record(err error) {
+	if err == nil {
+		h.failures.Store(0)
+		return
+	}
+	failures := h.failures.Add(1)
+	msg := err.Error()
+	h.lastErr.Store(&msg)
+
+	now := time.Now().UnixNano()
+	last := h.lastWarn.Load()
+	if now-last >= int64(exportFailureWarnInterval) && h.lastWarn.CompareAndSwap(last, now) {
+		slog.Warn("span export failing, telemetry is degraded",
+			"consecutive_failures", failures,
+			"error", err,
+		)
+	}
+}
+
+// collector exposes the consecutive export failures as a Prometheus gauge.
+func (h *exportHealth) collector() prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "span_export_consecutive_failures",
+		Help: "Number of span exports that failed in a row.",
+	}, func() float64 {
+		return float64(h.failures.Load())
+	})
+}
+
+// report returns the health check response for the current export state.
+func (h *exportHealth) report() HealthResponse {
+	if h == nil {
+		return HealthResponse{Status: "ok"}
+	}
+	failures := h.failures.Load()
+	if failures < degradedAfterExportFailures {
+		return HealthResponse{Status: "ok"}
+	}
+	resp := HealthResponse{Status: "degraded", Degraded: true, ConsecutiveExportFailures: failures}
+	if msg := h.lastErr.Load(); msg != nil {
+		resp.LastExportError = *msg
+	}
+	return resp
+}
+
+// monitoredExporter reports the outcome of every export to an exportHealth.
+type monitoredExporter struct {
+	sdktrace.SpanExporter
+	health *exportHealth
+}
+
+func (e monitoredExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	e.health.record(err)
+	e.health.queued.Add(-int64(len(spans)))
+	return err
+}
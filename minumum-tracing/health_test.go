@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// failingExporter is a span exporter whose exports fail with err.
+type failingExporter struct {
+	*tracetest.InMemoryExporter
+	err error
+}
+
+func (e failingExporter) ExportSpans(context.Context, []sdktrace.ReadOnlySpan) error { return e.err }
+
+func TestExportFailureWarning(t *testing.T) {
+	logs := captureLogs(t)
+	health := new(exportHealth)
+	exp := monitoredExporter{SpanExporter: failingExporter{tracetest.NewInMemoryExporter(), errCollectorUnreachable}, health: health}
+	gauge := health.collector()
+
+	for range 3 {
+		exp.ExportSpans(context.Background(), nil)
+	}
+
+	if n := countLogs(logs(), "span export failing, telemetry is degraded"); n != 1 {
+		t.Errorf("logged %d warnings for 3 failures, want 1 within the interval", n)
+	}
+	if got := gaugeValue(t, gauge); got != 3 {
+		t.Errorf("span_export_consecutive_failures = %v, want 3", got)
+	}
+	if report := health.report(); !report.Degraded || report.LastExportError != errCollectorUnreachable.Error() {
+		t.Errorf("health = %+v, want degraded with the last error", report)
+	}
+
+	// Once the interval has elapsed, the next failure is reported again.
+	health.lastWarn.Store(time.Now().Add(-exportFailureWarnInterval).UnixNano())
+	exp.ExportSpans(context.Background(), nil)
+	if n := countLogs(logs(), "span export failing, telemetry is degraded"); n != 2 {
+		t.Errorf("logged %d warnings after the interval, want 2", n)
+	}
+
+	health.record(nil)
+	if got := gaugeValue(t, gauge); got != 0 {
+		t.Errorf("span_export_consecutive_failures after a success = %v, want 0", got)
+	}
+}
+
+// countLogs returns how many of entries have the message msg.
+func countLogs(entries []map[string]any, msg string) int {
+	n := 0
+	for _, e := range entries {
+		if e["msg"] == msg {
+			n++
+		}
+	}
+	return n
+}
+
+// gaugeValue returns the value of the single gauge collected by c.
+func gaugeValue(t *testing.T, c prometheus.Collector) float64 {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(c)
+	families, err := reg.Gather()
+	if err != nil || len(families) != 1 {
+		t.Fatalf("Gather() = %d families (%v), want 1", len(families), err)
+	}
+	return families[0].GetMetric()[0].GetGauge().GetValue()
+}
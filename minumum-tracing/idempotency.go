@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the memory a single cache entry can hold in
+// keys.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyCache remembers the first response for each Idempotency-Key, so
+// that a client retrying a request gets the same answer instead of having it
+// processed again. It holds at most size entries, evicting the least
+// recently used one, and forgets entries after ttl.
+type idempotencyCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	order   *list.List // of *idempotencyEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type idempotencyEntry struct {
+	key     string
+	expires time.Time
+	// done is false while the first request is still being processed.
+	done   bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newIdempotencyCache(ttl time.Duration, size int) *idempotencyCache {
+	return &idempotencyCache{
+		ttl:     ttl,
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// wrap replays the cached response for a known Idempotency-Key, answers 409
+// while the first request with that key is still in flight, and otherwise
+// serves the request with next and caches its response. Server errors are
+// not cached so that they can be retried. Requests without the header, or
+// with a nil cache, are passed through.
+func (c *idempotencyCache) wrap(next http.HandlerFunc) http.HandlerFunc {
+	if c == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+		ctx := r.Context()
+		span := trace.SpanFromContext(ctx)
+		if len(key) > maxIdempotencyKeyLength {
+			writeJSONError(w, http.StatusBadRequest, idempotencyKeyHeader+" is too long", traceIDFromContext(ctx))
+			return
+		}
+
+		entry, found := c.begin(key, time.Now())
+		span.SetAttributes(attribute.Bool("idempotent.replayed", found && entry.done))
+		if found {
+			if !entry.done {
+				writeJSONError(w, http.StatusConflict, "a request with this "+idempotencyKeyHeader+" is in progress", traceIDFromContext(ctx))
+				return
+			}
+			for k, v := range entry.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+
+		// Only the headers set by next are replayed, not those of the outer
+		// middlewares such as X-Request-Id.
+		before := w.Header().Clone()
+		rec := &capturingWriter{ResponseWriter: w, status: http.StatusOK}
+		finished := false
+		defer func() {
+			if !finished {
+				// next panicked, so free the key for a retry rather than
+				// answering 409 until the reservation expires.
+				c.release(key)
+			}
+		}()
+		next(rec, r)
+		c.finish(key, rec, addedHeaders(before, w.Header()), time.Now())
+		finished = true
+	}
+}
+
+// begin returns the live entry for key, or reserves a new in-flight one and
+// reports that none was found.
+func (c *idempotencyCache) begin(key string, now time.Time) (idempotencyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*idempotencyEntry)
+		if now.Before(entry.expires) {
+			c.order.MoveToFront(el)
+			return *entry, true
+		}
+		c.remove(el)
+	}
+
+	c.entries[key] = c.order.PushFront(&idempotencyEntry{key: key, expires: now.Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+	return idempotencyEntry{}, false
+}
+
+// finish stores the response captured for key, or releases the key if the
+// response is a server error.
+func (c *idempotencyCache) finish(key string, rec *capturingWriter, header http.Header, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		// Evicted while the request was processed.
+		return
+	}
+	if rec.status >= http.StatusInternalServerError {
+		c.remove(el)
+		return
+	}
+	entry := el.Value.(*idempotencyEntry)
+	entry.done = true
+	entry.expires = now.Add(c.ttl)
+	entry.status = rec.status
+	entry.header = header
+	entry.body = rec.body.Bytes()
+}
+
+// release drops the in-flight reservation for key, if it is still there.
+func (c *idempotencyCache) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok && !el.Value.(*idempotencyEntry).done {
+		c.remove(el)
+	}
+}
+
+func (c *idempotencyCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*idempotencyEntry).key)
+}
+
+// addedHeaders returns the headers of after that are not in before with the
+// same values.
+func addedHeaders(before, after http.Header) http.Header {
+	added := make(http.Header)
+	for k, v := range after {
+		if !slices.Equal(before[k], v) {
+			added[k] = slices.Clone(v)
+		}
+	}
+	return added
+}
+
+// capturingWriter passes the response through while keeping a copy of its
+// status and body.
+type capturingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *capturingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idempotentRequest returns a POST /echo request with the Idempotency-Key key.
+func idempotentRequest(key, message string) *http.Request {
+	r := httptest.NewRequest("POST", "/echo", strings.NewReader(fmt.Sprintf(`{"message":%q}`, message)))
+	r.Header.Set(idempotencyKeyHeader, key)
+	return r
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	first := serve(s, idempotentRequest("key-1", "first"))
+	if v, _ := spanAttr(findSpan(t, exp, "echo-handler"), "idempotent.replayed"); v.AsBool() {
+		t.Error("first request: idempotent.replayed = true, want false")
+	}
+	exp.Reset()
+
+	replay := serve(s, idempotentRequest("key-1", "second"))
+
+	if replay.Code != first.Code || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %q, want the first response %d %q", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if v, _ := spanAttr(findSpan(t, exp, "echo-handler"), "idempotent.replayed"); !v.AsBool() {
+		t.Error("replay: idempotent.replayed = false, want true")
+	}
+	if other := serve(s, idempotentRequest("key-2", "second")); !strings.Contains(other.Body.String(), "second") {
+		t.Errorf("another key = %q, want the request processed", other.Body)
+	}
+}
+
+func TestIdempotencyExpiry(t *testing.T) {
+	calls := 0
+	h := newIdempotencyCache(20*time.Millisecond, 10).wrap(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		fmt.Fprint(w, calls)
+	})
+
+	serve(h, idempotentRequest("key", "hi"))
+	serve(h, idempotentRequest("key", "hi"))
+	if calls != 1 {
+		t.Fatalf("handler called %d times within the TTL, want 1", calls)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	if w := serve(h, idempotentRequest("key", "hi")); w.Body.String() != "2" || calls != 2 {
+		t.Errorf("after the TTL: body %q after %d calls, want the request processed again", w.Body, calls)
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	c := newIdempotencyCache(time.Minute, 10)
+	var inner *httptest.ResponseRecorder
+	h := c.wrap(func(w http.ResponseWriter, _ *http.Request) {
+		if inner == nil {
+			inner = serve(c.wrap(func(http.ResponseWriter, *http.Request) {
+				t.Error("concurrent request with the same key was processed")
+			}), idempotentRequest("key", "hi"))
+		}
+	})
+
+	serve(h, idempotentRequest("key", "hi"))
+
+	if inner.Code != http.StatusConflict {
+		t.Errorf("concurrent request status = %d, want %d", inner.Code, http.StatusConflict)
+	}
+}
+
+func TestIdempotencyReleasedAfterPanic(t *testing.T) {
+	calls := 0
+	h := newIdempotencyCache(time.Minute, 10).wrap(func(w http.ResponseWriter, _ *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		fmt.Fprint(w, "ok")
+	})
+
+	func() {
+		defer func() { recover() }()
+		serve(h, idempotentRequest("key", "hi"))
+	}()
+	w := serve(h, idempotentRequest("key", "hi"))
+
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("retry after a panic = %d %q, want it processed", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// loadShedRetryAfter is the Retry-After, in seconds, sent with shed requests.
+const loadShedRetryAfter = 1
+
+// loadShedder caps the number of requests handled at once across all traced
+// routes.
+type loadShedder struct {
+	max      int64
+	inflight atomic.Int64
+}
+
+func newLoadShedder(maxInflight int) *loadShedder {
+	return &loadShedder{max: int64(maxInflight)}
+}
+
+// middleware rejects requests with 503 while max others are in flight. A nil
+// loadShedder disables shedding.
+func (ls *loadShedder) middleware(next http.Handler) http.Handler {
+	if ls == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := ls.inflight.Add(1)
+		defer ls.inflight.Add(-1)
+		if n <= ls.max {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		trace.SpanFromContext(ctx).AddEvent("load_shed", trace.WithAttributes(
+			attribute.Int64("inflight", n-1),
+			attribute.Int64("max_inflight", ls.max),
+		))
+		w.Header().Set("Retry-After", strconv.Itoa(loadShedRetryAfter))
+		writeJSONError(w, http.StatusServiceUnavailable, "server is overloaded", traceIDFromContext(ctx))
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestLoadShedding(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := newLoadShedder(2).middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serve(h, httptest.NewRequest("GET", "/echo/hi", nil)).Code
+		}()
+		<-started
+	}
+
+	exp := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+	defer tp.Shutdown(context.Background())
+	ctx, span := tp.Tracer("test").Start(context.Background(), "request")
+	shed := serve(h, httptest.NewRequest("GET", "/echo/hi", nil).WithContext(ctx))
+	span.End()
+
+	close(release)
+	wg.Wait()
+	if shed.Code != http.StatusServiceUnavailable || shed.Header().Get("Retry-After") != "1" {
+		t.Errorf("request beyond MAX_INFLIGHT = %d, Retry-After %q, want 503 and 1", shed.Code, shed.Header().Get("Retry-After"))
+	}
+	if events := findSpan(t, exp, "request").Events; len(events) != 1 || events[0].Name != "load_shed" {
+		t.Errorf("span events = %+v, want a load_shed event", events)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d within MAX_INFLIGHT: status = %d, want %d", i, code, http.StatusOK)
+		}
+	}
+
+	// The slots are freed once the requests are done.
+	go func() { <-started }()
+	if w := serve(h, httptest.NewRequest("GET", "/echo/hi", nil)); w.Code != http.StatusOK {
+		t.Errorf("request after the others finished: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+// Fields that Cloud Logging uses to correlate a log entry with a trace.
+// https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+const (
+	cloudLoggingTraceKey        = "logging.googleapis.com/trace"
+	cloudLoggingSpanIDKey       = "logging.googleapis.com/spanId"
+	cloudLoggingTraceSampledKey = "logging.googleapis.com/trace_sampled"
+)
+
+// newLogHandler builds the handler of the default logger, writing to w in
+// LOG_FORMAT, with the source location when LOG_SOURCE is set, and dropping
+// records below level.
+func newLogHandler(w io.Writer, cfg Config, level slog.Leveler) slog.Handler {
+	opts := &slog.HandlerOptions{
+		AddSource: cfg.LogSource,
+		Level:     level,
+	}
+	var h slog.Handler = slog.NewJSONHandler(w, opts)
+	if cfg.LogFormat == "text" {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return newContextHandler(h, cfg.ProjectID)
+}
+
+// contextHandler adds request-scoped values stored in the context, such as
+// the active trace and span IDs, to every log record.
+type contextHandler struct {
+	slog.Handler
+
+	// projectID enables the Cloud Logging trace fields when non-empty.
+	projectID string
+}
+
+func newContextHandler(h slog.Handler, projectID string) *contextHandler {
+	return &contextHandler{Handler: h, projectID: projectID}
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	c := correlationFromContext(ctx)
+	if c.TraceID != "" {
+		r.AddAttrs(
+			slog.String("trace_id", c.TraceID),
+			slog.String("span_id", c.SpanID),
+		)
+		if h.projectID != "" {
+			r.AddAttrs(
+				slog.String(cloudLoggingTraceKey, "projects/"+h.projectID+"/traces/"+c.TraceID),
+				slog.String(cloudLoggingSpanIDKey, c.SpanID),
+				slog.Bool(cloudLoggingTraceSampledKey, c.Sampled),
+			)
+		}
+	}
+	if c.RequestID != "" {
+		r.AddAttrs(slog.String("request_id", c.RequestID))
+	}
+	if c.TenantID != "" {
+		r.AddAttrs(slog.String("tenant_id", c.TenantID))
+	}
+	r.AddAttrs(baggageAttrsFromContext(ctx)...)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return newContextHandler(h.Handler.WithAttrs(attrs), h.projectID)
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return newContextHandler(h.Handler.WithGroup(name), h.projectID)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// testSpanContext is a sampled span context for a fixed trace.
+var testSpanContext = trace.NewSpanContext(trace.SpanContextConfig{
+	TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+	SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+	TraceFlags: trace.FlagsSampled,
+})
+
+// captureLogs sends the default logger to a JSON handler, with the context
+// fields added, until the end of the test and returns a function decoding
+// the entries logged so far.
+func captureLogs(t *testing.T) func() []map[string]any {
+	t.Helper()
+	var (
+		mu  sync.Mutex
+		buf bytes.Buffer
+	)
+	prev := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	w := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return buf.Write(p)
+	})
+	slog.SetDefault(slog.New(newContextHandler(slog.NewJSONHandler(w, nil), "")))
+
+	return func() []map[string]any {
+		t.Helper()
+		mu.Lock()
+		defer mu.Unlock()
+		var entries []map[string]any
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var entry map[string]any
+			if err := json.Unmarshal(line, &entry); err != nil {
+				t.Fatalf("decode log entry %q: %v", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+}
+
+// findLog returns the first entry with msg.
+func findLog(t *testing.T, entries []map[string]any, msg string) map[string]any {
+	t.Helper()
+	for _, entry := range entries {
+		if entry["msg"] == msg {
+			return entry
+		}
+	}
+	t.Fatalf("no %q entry among %d log entries", msg, len(entries))
+	return nil
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// logRecord logs msg with ctx through a contextHandler for projectID and
+// returns the decoded JSON entry.
+func logRecord(t *testing.T, ctx context.Context, projectID string) map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	slog.New(newContextHandler(slog.NewJSONHandler(&buf, nil), projectID)).InfoContext(ctx, "hello")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log entry %q: %v", buf.String(), err)
+	}
+	return entry
+}
+
+func TestContextHandlerCloudLoggingFields(t *testing.T) {
+	ctx := trace.ContextWithSpanContext(context.Background(), testSpanContext)
+
+	entry := logRecord(t, ctx, "my-project")
+
+	want := map[string]any{
+		cloudLoggingTraceKey:        "projects/my-project/traces/4bf92f3577b34da6a3ce929d0e0e4736",
+		cloudLoggingSpanIDKey:       "00f067aa0ba902b7",
+		cloudLoggingTraceSampledKey: true,
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("%s = %v, want %v", k, entry[k], v)
+		}
+	}
+}
+
+func TestContextHandlerWithoutProject(t *testing.T) {
+	ctx := trace.ContextWithSpanContext(context.Background(), testSpanContext)
+
+	entry := logRecord(t, ctx, "")
+
+	if _, ok := entry[cloudLoggingTraceKey]; ok {
+		t.Errorf("%s logged without a project ID", cloudLoggingTraceKey)
+	}
+}
+
+func TestContextHandlerTraceFields(t *testing.T) {
+	ctx := trace.ContextWithSpanContext(context.Background(), testSpanContext)
+
+	entry := logRecord(t, ctx, "")
+
+	if entry["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || entry["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("trace_id, span_id = %v, %v, want the active span", entry["trace_id"], entry["span_id"])
+	}
+
+	entry = logRecord(t, context.Background(), "")
+	if _, ok := entry["trace_id"]; ok {
+		t.Error("trace_id logged without an active span")
+	}
+}
+
+func TestNewLogHandlerLevel(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "info")
+	cfg := testConfig(t)
+	level := new(slog.LevelVar)
+	level.Set(cfg.LogLevel)
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler(&buf, cfg, level))
+
+	logger.Debug("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("debug log emitted at info: %s", buf.String())
+	}
+
+	level.Set(slog.LevelDebug)
+	logger.Debug("shown")
+	if !bytes.Contains(buf.Bytes(), []byte(`"msg":"shown"`)) {
+		t.Errorf("debug log not emitted at debug: %q", buf.String())
+	}
+}
+
+func TestLogLevelEnv(t *testing.T) {
+	t.Setenv("LOG_LEVEL", "debug")
+	if cfg := testConfig(t); cfg.LogLevel != slog.LevelDebug {
+		t.Errorf("LogLevel = %v, want debug", cfg.LogLevel)
+	}
+
+	t.Setenv("LOG_LEVEL", "verbose")
+	if _, err := LoadConfig(); err == nil {
+		t.Error("LoadConfig() accepted LOG_LEVEL=verbose")
+	}
+}
+
+func TestNewLogHandlerSource(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := testConfig(t)
+		cfg.LogSource = enabled
+		var buf bytes.Buffer
+		slog.New(newLogHandler(&buf, cfg, slog.LevelInfo)).Info("hello")
+
+		var entry map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+			t.Fatalf("decode log entry %q: %v", buf.String(), err)
+		}
+		source, ok := entry[slog.SourceKey].(map[string]any)
+		if ok != enabled {
+			t.Errorf("LOG_SOURCE=%t: source = %v", enabled, entry[slog.SourceKey])
+		}
+		if enabled && source["file"] == nil {
+			t.Errorf("source = %v, want the file and line", source)
+		}
+	}
+}
+
+func TestNewLogHandlerTextFormat(t *testing.T) {
+	t.Setenv("LOG_FORMAT", "text")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "my-project")
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler(&buf, testConfig(t), slog.LevelInfo))
+
+	logger.InfoContext(trace.ContextWithSpanContext(context.Background(), testSpanContext), "hello", "answer", 42)
+
+	line := buf.String()
+	if strings.HasPrefix(line, "{") || !strings.Contains(line, "msg=hello") || !strings.Contains(line, "answer=42") {
+		t.Errorf("log line = %q, want key=value text", line)
+	}
+	// The correlation fields are added whichever the format.
+	if !strings.Contains(line, "trace_id="+testSpanContext.TraceID().String()) {
+		t.Errorf("log line = %q, want the trace ID", line)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// openLogOutput returns the writer named by LOG_OUTPUT: stdout, stderr, or
+// the path of a file that logs are appended to. The returned close function
+// flushes and closes the file, and does nothing for the standard streams.
+func openLogOutput(v string) (io.Writer, func() error, error) {
+	switch v {
+	case "", "stdout":
+		return os.Stdout, func() error { return nil }, nil
+	case "stderr":
+		return os.Stderr, func() error { return nil }, nil
+	}
+	f, err := os.OpenFile(v, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid LOG_OUTPUT: %w", err)
+	}
+	return f, func() error { return errors.Join(f.Sync(), f.Close()) }, nil
+}
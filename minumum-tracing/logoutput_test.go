@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenLogOutputFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	t.Setenv("LOG_OUTPUT", path)
+	cfg := testConfig(t)
+
+	w, closeOutput, err := openLogOutput(cfg.LogOutput)
+	if err != nil {
+		t.Fatalf("openLogOutput() error = %v", err)
+	}
+	slog.New(newLogHandler(w, cfg, slog.LevelInfo)).Info("to a file", "answer", 42)
+	if err := closeOutput(); err != nil {
+		t.Fatalf("close error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("log file holds %q, want a JSON entry: %v", data, err)
+	}
+	if entry["msg"] != "to a file" || entry["answer"] != 42.0 {
+		t.Errorf("entry = %v, want the logged message and attribute", entry)
+	}
+}
+
+func TestOpenLogOutputStreams(t *testing.T) {
+	for v, want := range map[string]*os.File{"": os.Stdout, "stdout": os.Stdout, "stderr": os.Stderr} {
+		w, closeOutput, err := openLogOutput(v)
+		if err != nil || w != want {
+			t.Errorf("openLogOutput(%q) = %v, %v, want %s", v, w, err, want.Name())
+			continue
+		}
+		if err := closeOutput(); err != nil {
+			t.Errorf("openLogOutput(%q) close error = %v, want the stream left open", v, err)
+		}
+	}
+}
+
+func TestOpenLogOutputInvalid(t *testing.T) {
+	_, _, err := openLogOutput(filepath.Join(t.TempDir(), "missing", "app.log"))
+	if err == nil || !strings.Contains(err.Error(), "LOG_OUTPUT") {
+		t.Errorf("openLogOutput() error = %v, want an invalid LOG_OUTPUT error", err)
+	}
+}
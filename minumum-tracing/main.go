@@ -1,123 +1,1000 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
-	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"go.opentelemetry.io/contrib/exporters/autoexport"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/netutil"
 )
 
+type Request struct {
+	Message string `json:"message"`
+}
+
 type Response struct {
 	Message string `json:"message"`
 }
 
-var (
-	echoPathRegex = regexp.MustCompile(`^/echo/(.+)$`)
+// defaultResponseField is the JSON field carrying the echoed message unless
+// RESPONSE_FIELD_NAME names another one.
+const defaultResponseField = "message"
+
+type ReadinessResponse struct {
+	Ready bool `json:"ready"`
+}
+
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+const (
+	// instrumentationName is the scope name of the tracer and meter used by
+	// this service.
+	instrumentationName = "github.com/ebi-yade/app-engine-samples/minumum-tracing"
+
+	defaultMaxBodyBytes = 64 << 10
+
+	defaultMaxMessageLength = 1024
+
+	// defaultHealthPath is where App Engine sends its health checks.
+	defaultHealthPath = "/_ah/health"
+)
+
+// reservedPaths are the GET routes registered by routes with a fixed path,
+// which HEALTH_PATH must not clash with. Keep it in sync with routes.
+var reservedPaths = []string{
+	"/",
+	"/healthz",
+	warmupPath,
+	"/metrics",
+	"/metrics/healthz",
+	"/version",
+	"/echo",
+	"/echo/ws",
+	"/outbound",
+	"/outbound/priority",
+	"/debug/spans",
+	"/debug/config",
+	"/debug/resource",
+	"/debug/requests",
+	"/debug/pprof/",
+	"/debug/pprof/cmdline",
+	"/debug/pprof/profile",
+	"/debug/pprof/symbol",
+	"/debug/pprof/trace",
+}
+
+// Default http.Server timeouts. They keep slow or idle clients from holding
+// connections forever and can be overridden with READ_TIMEOUT,
+// READ_HEADER_TIMEOUT, WRITE_TIMEOUT and IDLE_TIMEOUT.
+const (
+	// defaultReadTimeout bounds reading the whole request, including the body.
+	defaultReadTimeout = 15 * time.Second
+	// defaultReadHeaderTimeout bounds reading the request headers, which is
+	// the main defense against Slowloris-style clients.
+	defaultReadHeaderTimeout = 5 * time.Second
+	// defaultWriteTimeout bounds the time from the end of the request headers
+	// to the end of the response write.
+	defaultWriteTimeout = 30 * time.Second
+	// defaultIdleTimeout bounds how long a keep-alive connection may sit idle.
+	defaultIdleTimeout = 120 * time.Second
 )
 
 type server struct {
-	tracer trace.Tracer
+	tracer    trace.Tracer
+	metrics   *metrics
+	echoCount metric.Int64Counter
+	echoDelay *delayRange
+
+	// requestTimeout bounds traced handlers. Zero disables the timeout.
+	requestTimeout time.Duration
+	// slowThreshold flags traced requests taking longer than it as slow.
+	// Zero disables the check. It holds nanoseconds so that SIGHUP can change
+	// it.
+	slowThreshold atomic.Int64
+	slo           *sloCounters
+	// maxMessageLength is the longest echo message accepted, in bytes.
+	maxMessageLength int
+	// maxMessageAttrLength is how many characters of a message are kept in
+	// the message span attribute.
+	maxMessageAttrLength int
+	// maxHeaderBytes is the largest header set accepted by traced handlers.
+	// Zero disables the check.
+	maxHeaderBytes int
+	// maxBodyBytes is the largest request body accepted by traced handlers.
+	maxBodyBytes int64
+	// gzipMinBytes is the smallest traced response that is gzipped. Zero
+	// disables compression.
+	gzipMinBytes int
+	// rateLimiter limits traced handlers per client. Nil disables it.
+	rateLimiter *rateLimiter
+	// outboundURL is called by GET /outbound. Empty disables the endpoint.
+	outboundURL    string
+	outboundClient *http.Client
+	// breaker guards the simulated downstream call of the enriched echo.
+	breaker *circuitBreaker
+	// enrichFailureRate is the probability of the simulated downstream call
+	// failing.
+	enrichFailureRate float64
+	// exportHealth tracks span export failures for the health check. Nil
+	// when spans are not exported.
+	exportHealth *exportHealth
+	// spanQueueSize is how many spans may wait to be exported.
+	spanQueueSize int
+	// spanRecorder holds finished spans when IN_MEMORY_SPANS is enabled.
+	spanRecorder *tracetest.InMemoryExporter
+	// enablePprof exposes /debug/pprof/ when ENABLE_PPROF is set.
+	enablePprof bool
+	// requestRecorder keeps recent requests for /debug/requests when
+	// RECORD_REQUESTS is set, and is nil otherwise.
+	requestRecorder *requestRecorder
+	// debugConfig is served on /debug/config when ENABLE_DEBUG is set, and
+	// nil otherwise.
+	debugConfig *Config
+	// resource describes this instance in exported telemetry, and is served
+	// on /debug/resource when ENABLE_DEBUG is set.
+	resource *resource.Resource
+	// adminToken enables the /admin endpoints, which require it in the
+	// X-Admin-Token header.
+	adminToken string
+	// requestShutdown starts a graceful shutdown as if SIGTERM was received.
+	requestShutdown func()
+	// logLevel is the minimum level of the default logger.
+	logLevel *slog.LevelVar
+	// basePath is the prefix all routes are mounted under, e.g. /api.
+	basePath string
+	// healthPath serves the health check, /_ah/health by default.
+	healthPath string
+	// outsideBasePath answers requests that do not start with basePath.
+	outsideBasePath http.Handler
+	// background tracks the tasks started by handleEchoBackground.
+	background sync.WaitGroup
+	// allowedTenants restricts the accepted X-Tenant-Id values when not
+	// empty.
+	allowedTenants []string
+	// idempotency replays responses to POST /echo retried with the same
+	// Idempotency-Key. Nil when disabled.
+	idempotency *idempotencyCache
+	// store backs the /store endpoints. Nil when disabled.
+	store *kvStore
+	// loadShedder rejects requests beyond MAX_INFLIGHT. Nil when disabled.
+	loadShedder *loadShedder
+	// coalescer shares the echo delay between concurrent requests for the
+	// same message. Nil when disabled.
+	coalescer *coalescer
+	// responseField is the JSON field carrying the echoed message.
+	responseField string
+	// traceIDHeader is the response header carrying the trace ID.
+	traceIDHeader string
+	// detailedSpans adds a span per middleware to traced routes.
+	detailedSpans bool
+	// startTime is when the process started, reported as uptime by /version.
+	startTime time.Time
+	// requestsServed counts the requests logged by accessLog.
+	requestsServed atomic.Int64
+	// inflight counts the requests being handled, and connections the open
+	// connections, for draining on shutdown.
+	inflight    atomic.Int64
+	connections atomic.Int64
+	// poolBuffers encodes JSON responses into pooled buffers.
+	poolBuffers bool
+	// prettyJSON makes JSON responses indented and leaves HTML characters
+	// unescaped, for reading them during development.
+	prettyJSON bool
+	// handlers are additional endpoints registered alongside the built-in
+	// ones.
+	handlers    []Handler
+	middlewares []Middleware
+	mux         http.ServeMux
+
+	// ready is set once main has finished initialization and the server is
+	// listening, and cleared again when shutdown starts.
+	ready atomic.Bool
+	// draining is set once shutdown starts.
+	draining atomic.Bool
+}
+
+// newServer builds the server from cfg, installs the middlewares and
+// registers the routes, including the given handlers. health and spanRecorder
+// are nil unless spans are exported or kept in memory, respectively.
+func newServer(cfg Config, logLevel *slog.LevelVar, health *exportHealth, spanRecorder *tracetest.InMemoryExporter, handlers ...Handler) (*server, error) {
+	meter := otel.Meter(instrumentationName)
+	echoCount, err := meter.Int64Counter("echo.requests",
+		metric.WithDescription("Number of echo requests handled."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create echo counter: %w", err)
+	}
+	slo, err := newSLOCounters(meter)
+	if err != nil {
+		return nil, fmt.Errorf("create SLO counters: %w", err)
+	}
+
+	var idempotency *idempotencyCache
+	if cfg.IdempotencyTTL > 0 {
+		idempotency = newIdempotencyCache(cfg.IdempotencyTTL, cfg.IdempotencyCacheSize)
+	}
+
+	var shedder *loadShedder
+	if cfg.MaxInflight > 0 {
+		shedder = newLoadShedder(cfg.MaxInflight)
+	}
+
+	var limiter *rateLimiter
+	if cfg.RateLimit > 0 {
+		limiter = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+
+	reg := prometheus.NewRegistry()
+	if health != nil {
+		reg.MustRegister(health.collector())
+	}
+
+	s := &server{
+		tracer:               otel.Tracer(instrumentationName),
+		metrics:              newMetrics(reg),
+		echoCount:            echoCount,
+		echoDelay:            newDelayRange(cfg.EchoDelayMin, cfg.EchoDelayMax, cfg.EchoDelaySeed),
+		requestTimeout:       cfg.RequestTimeout,
+		slo:                  slo,
+		rateLimiter:          limiter,
+		outboundURL:          cfg.OutboundURL,
+		outboundClient:       newOutboundClient(),
+		breaker:              newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		enrichFailureRate:    cfg.EnrichFailureRate,
+		maxMessageLength:     cfg.MaxMessageLength,
+		maxMessageAttrLength: cfg.MaxMessageAttrLength,
+		maxHeaderBytes:       cfg.MaxHeaderBytes,
+		maxBodyBytes:         cfg.MaxBodyBytes,
+		gzipMinBytes:         cfg.GzipMinBytes,
+		exportHealth:         health,
+		spanQueueSize:        cfg.SpanQueueSize,
+		spanRecorder:         spanRecorder,
+		enablePprof:          cfg.EnablePprof,
+		adminToken:           cfg.AdminToken,
+		logLevel:             logLevel,
+		basePath:             cfg.BasePath,
+		healthPath:           cfg.HealthPath,
+		allowedTenants:       cfg.AllowedTenants,
+		idempotency:          idempotency,
+		detailedSpans:        cfg.DetailedSpans,
+		responseField:        cfg.ResponseField,
+		traceIDHeader:        cfg.TraceIDHeader,
+		loadShedder:          shedder,
+		prettyJSON:           cfg.PrettyJSON,
+		poolBuffers:          cfg.PoolBuffers,
+		handlers:             handlers,
+	}
+	s.slowThreshold.Store(int64(cfg.SlowThreshold))
+	if cfg.EnableDebug {
+		s.debugConfig = &cfg
+	}
+	if cfg.RecordRequests {
+		s.requestRecorder = newRequestRecorder()
+	}
+	if cfg.Coalesce {
+		s.coalescer = new(coalescer)
+	}
+	if cfg.StoreSize > 0 {
+		s.store = newKVStore(cfg.StoreSize)
+	}
+	s.use(
+		countInflight(&s.inflight),
+		resolveClientIP(cfg.TrustedProxyHops),
+		defaultHeaders(cfg.DefaultHeaders),
+		requestID,
+		s.requestRecorder.middleware,
+		accessLog(&s.requestsServed, cfg.AccessLogSampleRate),
+		baggageLogging,
+		s.metrics.middleware,
+		recovery,
+		cors(s.tracer, cfg.AllowedOrigins),
+	)
+	s.routes()
+	return s, nil
+}
+
+// startDraining marks the server as not ready before the graceful shutdown
+// begins, so that health checks stop routing new requests to it while the
+// in-flight ones finish.
+func (s *server) startDraining() {
+	s.ready.Store(false)
+	s.draining.Store(true)
+}
+
+// use appends middlewares applied to every route registered afterwards,
+// outermost first.
+func (s *server) use(mws ...Middleware) {
+	s.middlewares = append(s.middlewares, mws...)
+}
+
+// routes registers the application endpoints. It must be called after all
+// middlewares have been added with use.
+func (s *server) routes() {
+	handlers := []Handler{
+		endpoint{"GET " + s.healthPath, http.HandlerFunc(s.handleHealth)},
+		endpoint{"GET /echo", s.traced("echo-handler", s.handleEcho)},
+		endpoint{"GET /echo/{message...}", s.traced("echo-handler", s.handleEcho)},
+		endpoint{"POST /echo", s.traced("echo-handler", s.idempotency.wrap(s.handleEchoPost))},
+	}
+	for _, h := range append(handlers, s.handlers...) {
+		s.handle(h.Pattern(), h)
+	}
+	s.handle("GET /healthz", http.HandlerFunc(s.handleReadiness))
+	s.handle("GET "+warmupPath, tracing(s.tracer, "warmup")(http.HandlerFunc(s.handleWarmup)))
+	s.handle("GET /metrics", s.metrics.handler)
+	if s.exportHealth != nil {
+		s.handle("GET /metrics/healthz", http.HandlerFunc(s.handleSpanQueue))
+	}
+	s.handle("GET /version", s.traced("version-handler", s.handleVersion))
+	// WebSocket connections outlive any request timeout and need the raw
+	// connection, so they skip the timeout and body counting.
+	s.handle("GET /echo/ws", chain(http.HandlerFunc(s.handleEchoWS),
+		tracing(s.tracer, "echo-ws-connection"),
+		s.rateLimiter.middleware,
+		recovery,
+	))
+	s.handle("GET /echo/{message}/stream", s.traced("echo-handler", s.handleEchoStream))
+	s.handle("POST /echo/{message}/background", s.traced("echo-background-handler", s.handleEchoBackground))
+	s.handle("GET /echo/{message}/fail", s.traced("echo-fail-handler", s.handleEchoFail))
+	s.handle("GET /echo/{message}/enriched", s.traced("echo-enriched-handler", s.handleEchoEnriched))
+	s.handle("GET /echo/{message}/nested", s.traced("echo-nested-handler", s.handleEchoNested))
+	s.handle("POST /echo/raw", s.traced("echo-raw-handler", s.handleEchoRaw))
+	s.handle("POST /echo/batch", s.traced("echo-batch-handler", s.handleEchoBatch))
+	if s.store != nil {
+		s.handle("PUT /store/{key}", s.traced("store-put-handler", s.handleStorePut))
+		s.handle("GET /store/{key}", s.traced("store-get-handler", s.handleStoreGet))
+	}
+	if s.outboundURL != "" {
+		s.handle("GET /outbound", s.traced("outbound-handler", s.handleOutbound))
+		s.handle("GET /outbound/priority", s.traced("outbound-priority-handler", s.handleOutboundPriority))
+	}
+	if s.spanRecorder != nil {
+		s.handle("GET /debug/spans", audit(http.HandlerFunc(s.handleDebugSpans)))
+		s.handle("GET /debug/trace/{traceID}", audit(http.HandlerFunc(s.handleDebugTrace)))
+	}
+	if s.enablePprof {
+		s.pprofRoutes()
+	}
+	if s.debugConfig != nil {
+		s.handle("GET /debug/config", audit(http.HandlerFunc(s.handleDebugConfig)))
+		s.handle("POST /debug/span", audit(http.HandlerFunc(s.handleDebugSpan)))
+		s.handle("GET /debug/resource", audit(http.HandlerFunc(s.handleDebugResource)))
+	}
+	if s.requestRecorder != nil {
+		s.handle("GET /debug/requests", audit(http.HandlerFunc(s.handleDebugRequests)))
+	}
+	if s.adminToken != "" {
+		s.handle("POST /admin/shutdown", audit(requireAdminToken(s.adminToken, s.handleAdminShutdown)))
+		s.handle("POST /admin/log-level", audit(requireAdminToken(s.adminToken, s.handleAdminLogLevel)))
+	}
+	s.handle("/", http.HandlerFunc(s.handleNotFound))
+	s.outsideBasePath = s.wrap("not_found", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), traceIDFromContext(r.Context()))
+	}))
+}
+
+// handle registers h for pattern, wrapped in the server middlewares. The
+// route template recorded in telemetry is the base path followed by the path
+// part of the pattern, or "not_found" for the catch-all.
+func (s *server) handle(pattern string, h http.Handler) {
+	route := pattern
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		route = path
+	}
+	route = s.basePath + strings.ReplaceAll(route, "...}", "}")
+	if route == s.basePath+"/" {
+		route = "not_found"
+	}
+	s.mux.Handle(pattern, s.wrap(route, h))
+}
+
+// wrap applies the server middlewares to h, recording route as its template.
+func (s *server) wrap(route string, h http.Handler) http.Handler {
+	mws := append([]Middleware{routeTemplate(route)}, s.middlewares...)
+	return chain(h, mws...)
 }
 
+// ServeHTTP strips the base path before routing, so that routes, including
+// the health checks, are matched without it. Requests outside the base path
+// are not found.
 func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch {
-	case r.URL.Path == "/_ah/health" && r.Method == http.MethodGet:
-		s.handleHealth(w, r)
-	case echoPathRegex.MatchString(r.URL.Path) && r.Method == http.MethodGet:
-		s.handleEcho(w, r)
-	default:
-		http.NotFound(w, r)
+	if s.basePath == "" {
+		s.mux.ServeHTTP(w, r)
+		return
 	}
+	stripped, ok := stripBasePath(r, s.basePath)
+	if !ok {
+		s.outsideBasePath.ServeHTTP(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, stripped)
 }
 
+// traced wraps h in a span, flags a malformed traceparent and conflicting
+// trace headers, returns the trace ID and sampling decision in headers,
+// rejects oversized headers, sheds load, records the caller's tenant,
+// negotiates the charset, compresses large responses, flags slow requests,
+// applies per-client rate limiting, the caller's deadline and the request
+// timeout, and recovers panics while that span is active. With
+// DETAILED_SPANS, each of those steps also gets a child span.
+func (s *server) traced(spanName string, h http.HandlerFunc) http.Handler {
+	mws := []namedMiddleware{
+		{"traceparent", checkTraceparent},
+		{"trace-headers", checkTraceHeaders},
+		{"trace-id", traceIDResponse(s.traceIDHeader)},
+		{"header-limit", headerLimit(s.maxHeaderBytes)},
+		{"load-shed", s.loadShedder.middleware},
+		{"tenant", tenant(s.allowedTenants)},
+		{"charset", acceptCharset},
+		{"compress", compress(s.gzipMinBytes)},
+		{"content-length", contentLength},
+		{"body-limit", bodyLimit(s.maxBodyBytes)},
+		{"slow-requests", slowRequests(&s.slowThreshold, s.slo)},
+		{"rate-limit", s.rateLimiter.middleware},
+		{"request-deadline", requestDeadline},
+		{"timeout", timeout(s.requestTimeout)},
+		{"recovery", recovery},
+	}
+	chained := []Middleware{tracing(s.tracer, spanName)}
+	if s.detailedSpans {
+		chained = append(chained, detailed(s.tracer, mws)...)
+	} else {
+		for _, m := range mws {
+			chained = append(chained, m.mw)
+		}
+	}
+	return chain(h, chained...)
+}
+
+// handleHealth answers 200 so that the instance is not restarted, but reports
+// degraded when span exports keep failing. While draining it answers 503.
 func (s *server) handleHealth(w http.ResponseWriter, _ *http.Request) {
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("ok"))
+	w.Header().Set("Content-Type", "application/json")
+	if s.draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{Status: "draining"})
+		return
+	}
+	json.NewEncoder(w).Encode(s.exportHealth.report())
+}
+
+func (s *server) handleReadiness(w http.ResponseWriter, _ *http.Request) {
+	ready := s.ready.Load()
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(ReadinessResponse{Ready: ready})
+}
+
+// handleNotFound answers requests that no route matched. If the path is
+// served under another method it responds 405 with an Allow header instead.
+func (s *server) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	if allowed := s.allowedMethods(r); len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeJSONError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed), traceIDFromContext(r.Context()))
+		return
+	}
+	writeJSONError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound), traceIDFromContext(r.Context()))
+}
+
+var routeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// allowedMethods reports the methods for which a route other than the
+// catch-all matches the request path.
+func (s *server) allowedMethods(r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		req := *r
+		req.Method = method
+		if _, pattern := s.mux.Handler(&req); pattern != "" && pattern != "/" {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
 }
 
 func (s *server) handleEcho(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
-	ctx, span := s.tracer.Start(ctx, "echo-handler")
-	defer span.End()
+	span := trace.SpanFromContext(ctx)
+	corr := correlationFromContext(ctx)
+	span.AddEvent("request received", trace.WithAttributes(
+		attribute.String("http.target", r.URL.RequestURI()),
+	))
+
+	message, source, err := echoMessage(r)
+	if errors.Is(err, errEmptyMessage) {
+		// /echo and /echo/ are known routes missing their message, so they
+		// are rejected as a bad request rather than reported as not found.
+		span.SetAttributes(attribute.Bool("message.empty", true))
+		writeJSONError(w, http.StatusBadRequest, "message is required: use /echo/{message} or /echo?message=", corr.TraceID)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error(), corr.TraceID)
+		return
+	}
+	span.SetAttributes(attribute.String("message.source", source))
+	if !s.checkMessageLength(w, r, message) {
+		return
+	}
+	span.SetAttributes(s.messageAttributes(message)...)
+
+	contentType, ok := negotiateContentType(r.Header.Get("Accept"), "application/json", "text/plain")
+	if !ok {
+		writeJSONError(w, http.StatusNotAcceptable, "supported content types are application/json and text/plain", corr.TraceID)
+		return
+	}
+	span.SetAttributes(attribute.String("http.response.content_type", contentType))
+	s.echoCount.Add(ctx, 1)
+
+	etag := echoETag(contentType, message)
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+	notModified := etagMatches(r.Header.Get("If-None-Match"), etag)
+	span.SetAttributes(attribute.Bool("http.response.not_modified", notModified))
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	slog.InfoContext(ctx, "received echo request", "message", message)
+
+	span.AddEvent("processing started", trace.WithAttributes(
+		attribute.Int("message.length", len(message)),
+	))
+	delay := s.echoDelay.next()
+	span.SetAttributes(attribute.String("echo.delay", delay.String()))
+	if delay > 0 {
+		// Stop waiting as soon as the client goes away or the request times
+		// out; nobody is left to read the response.
+		coalesced, err := s.coalescer.wait(ctx, message, delay)
+		if err != nil {
+			span.AddEvent("client_disconnected", trace.WithAttributes(
+				attribute.String("error", err.Error()),
+			))
+			return
+		}
+		if coalesced {
+			span.SetAttributes(attribute.Bool("coalesced", true))
+		}
+	}
+
+	if contentType == "text/plain" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(message))
+	} else {
+		s.writeJSON(ctx, w, s.echoResponse(message))
+	}
+	span.AddEvent("response written", trace.WithAttributes(
+		attribute.String("content_type", contentType),
+	))
+}
+
+// echoMessage returns the message of a GET echo request and where it came
+// from. The path segment takes precedence over the message query parameter.
+func echoMessage(r *http.Request) (message, source string, err error) {
+	// Decode from the escaped path so that an encoded slash (%2F) belongs to
+	// the message while a literal one starts another segment.
+	raw := strings.TrimPrefix(r.URL.EscapedPath(), "/echo")
+	raw = strings.TrimPrefix(raw, "/")
+	if raw != "" {
+		if strings.Contains(raw, "/") {
+			return "", "", errors.New("message must be a single path segment")
+		}
+		message, err := url.PathUnescape(raw)
+		if err != nil {
+			return "", "", errors.New("invalid message encoding")
+		}
+		return message, "path", nil
+	}
+
+	if message := r.URL.Query().Get("message"); message != "" {
+		return message, "query", nil
+	}
+	return "", "", errEmptyMessage
+}
+
+// checkMessageLength rejects messages longer than maxMessageLength bytes with
+// 413 and reports whether the message is acceptable.
+func (s *server) checkMessageLength(w http.ResponseWriter, r *http.Request, message string) bool {
+	if len(message) <= s.maxMessageLength {
+		return true
+	}
+
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("message.length", len(message)))
+	span.SetStatus(codes.Error, "message too long")
+	writeJSONError(w, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("message exceeds %d bytes", s.maxMessageLength), traceIDFromContext(ctx))
+	return false
+}
+
+func (s *server) handleEchoPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if req.Message == "" {
+		writeJSONError(w, http.StatusBadRequest, errEmptyMessage.Error(), traceIDFromContext(ctx))
+		return
+	}
+	if !s.checkMessageLength(w, r, req.Message) {
+		return
+	}
 
-	matches := echoPathRegex.FindStringSubmatch(r.URL.Path)
-	if len(matches) != 2 {
-		http.Error(w, "invalid path", http.StatusBadRequest)
+	slog.InfoContext(ctx, "received echo request", "message", req.Message)
+
+	s.writeJSON(ctx, w, s.echoResponse(req.Message))
+}
+
+// echoResponse is the JSON body echoing message, under the field named by
+// RESPONSE_FIELD_NAME.
+func (s *server) echoResponse(message string) any {
+	return s.withResponseField(Response{Message: message})
+}
+
+// withResponseField returns v, an echo response carrying the message in its
+// "message" field, with that field renamed as RESPONSE_FIELD_NAME says. The
+// other fields are kept, so that every echo route follows the same schema.
+func (s *server) withResponseField(v any) any {
+	if s.responseField == defaultResponseField {
+		return v
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// writeJSON escapes the result as it is configured to.
+	enc.SetEscapeHTML(false)
+	var fields map[string]json.RawMessage
+	if err := enc.Encode(v); err != nil || json.Unmarshal(buf.Bytes(), &fields) != nil {
+		return v
+	}
+	if message, ok := fields[defaultResponseField]; ok {
+		delete(fields, defaultResponseField)
+		fields[s.responseField] = message
+	}
+	return fields
+}
+
+// writeDecodeError answers a request whose body could not be decoded, with
+// 413 if the body exceeded the limit set by bodyLimit and 400 otherwise.
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit), traceIDFromContext(r.Context()))
 		return
 	}
-	message := matches[1]
+	writeJSONError(w, http.StatusBadRequest, "invalid request body", traceIDFromContext(r.Context()))
+}
 
-	slog.InfoContext(ctx, "received echo request",
-		"message", message,
-		"trace_id", span.SpanContext().TraceID().String(),
+// writeJSON encodes v as the response body, indented and without HTML
+// escaping when PRETTY_JSON is set. With POOL_BUFFERS, v is encoded into a
+// pooled buffer first. Encoding errors can only be reported on the span and
+// in logs because the headers are already sent.
+func (s *server) writeJSON(ctx context.Context, w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	var (
+		out io.Writer = w
+		buf *bytes.Buffer
 	)
+	if s.poolBuffers {
+		buf = getBuffer()
+		defer putBuffer(buf)
+		out = buf
+	}
+	enc := json.NewEncoder(out)
+	if s.prettyJSON {
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+	}
+	err := enc.Encode(v)
+	if err == nil && buf != nil {
+		_, err = w.Write(buf.Bytes())
+	}
+	if isClientDisconnect(err) {
+		// The client went away mid-response, which is routine and leaves
+		// nothing to fix on this side.
+		trace.SpanFromContext(ctx).AddEvent("client_disconnected", trace.WithAttributes(
+			attribute.String("error", err.Error()),
+		))
+		slog.DebugContext(ctx, "client disconnected before the response was written", "error", err)
+		return
+	}
+	if err != nil {
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to encode response")
+		slog.ErrorContext(ctx, "failed to encode response", "error", err)
+	}
+}
 
-	time.Sleep(100 * time.Millisecond)
+// isClientDisconnect reports whether err comes from writing to a connection
+// the client already closed.
+func isClientDisconnect(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
 
+func writeJSONError(w http.ResponseWriter, status int, msg, traceID string) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(Response{Message: message})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: msg, TraceID: traceID})
+}
+
+// traceIDFromContext returns the trace ID of the active span, or an empty
+// string if the request is not traced.
+func traceIDFromContext(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// listen opens the TCP listener for cfg.Addr, accepting at most
+// MaxConnections connections at once when it is set. When the address is
+// already taken, the error says which setting it came from and how to free it
+// up.
+func listen(cfg Config) (net.Listener, error) {
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		return nil, fmt.Errorf("address %s from %s is already in use; stop the process listening on it or set PORT or -addr to a free port: %w",
+			cfg.Addr, cfg.AddrSource, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxConnections > 0 {
+		// Connections beyond the limit wait in the accept backlog until an
+		// open one is closed.
+		ln = netutil.LimitListener(ln, cfg.MaxConnections)
+		slog.Info("limiting simultaneous connections", "max_connections", cfg.MaxConnections)
+	}
+	return ln, nil
+}
+
+// serveListener serves httpServer on ln, over TLS when TLS_CERT_FILE and
+// TLS_KEY_FILE are set. Shutdown closes the listener either way, so serving
+// over TLS needs no special handling when draining.
+func serveListener(httpServer *http.Server, ln net.Listener, cfg Config) error {
+	if cfg.TLSCertFile != "" {
+		return httpServer.ServeTLS(ln, cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+	return httpServer.Serve(ln)
 }
 
 func main() {
+	start := time.Now()
 	ctx := context.Background()
 
-	exp, err := autoexport.NewSpanExporter(ctx)
+	// -addr takes precedence over PORT, which takes precedence over the
+	// default port.
+	addr := flag.String("addr", "", "address to listen on, as host:port, overriding PORT")
+	flag.Parse()
+
+	// The logger is set up from the configuration even when it is invalid,
+	// so that the errors can be reported.
+	cfg, cfgErr := LoadConfig()
+	if *addr != "" {
+		cfgErr = errors.Join(cfgErr, cfg.overrideAddr(*addr))
+	}
+
+	logOutput, closeLogOutput, err := openLogOutput(cfg.LogOutput)
 	if err != nil {
-		slog.Error("failed to create span exporter", "error", err)
+		logOutput = os.Stdout
+		cfgErr = errors.Join(cfgErr, err)
+	} else {
+		// Closed once main returns, after the shutdown has been logged.
+		defer closeLogOutput()
+	}
+
+	// logLevel can be changed while the server runs.
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.LogLevel)
+	instanceID := newInstanceID()
+	logger := slog.New(newLogHandler(logOutput, cfg, logLevel)).With("instance_id", instanceID)
+	slog.SetDefault(logger)
+	if cfgErr != nil {
+		slog.Error("invalid configuration", "error", cfgErr)
 		os.Exit(1)
 	}
+	slog.Info("configuration loaded", "config", cfg)
 
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(exp),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	res, err := newResource(ctx, instanceID)
+	if err != nil {
+		slog.Error("failed to create resource", "error", err)
+		os.Exit(1)
+	}
+
+	mp, err := setupMetrics(ctx, res)
+	if err != nil {
+		slog.Error("failed to set up metrics", "error", err)
+		os.Exit(1)
+	}
+	if err := registerUptime(otel.Meter(instrumentationName), start); err != nil {
+		slog.Error("failed to register uptime gauge", "error", err)
+		os.Exit(1)
+	}
+
+	// SIGHUP can change the sampling ratio while the server runs.
+	sampler, err := newReloadableSampler(cfg.Sampler, cfg.SamplerRatio)
+	if err != nil {
+		slog.Error("failed to create sampler", "error", err)
+		os.Exit(1)
+	}
+	var (
+		tp           *sdktrace.TracerProvider
+		spanRecorder *tracetest.InMemoryExporter
+		health       *exportHealth
 	)
-	defer tp.ForceFlush(ctx)
+	switch {
+	case cfg.DisableTracing:
+		err = disableTracing(cfg)
+		slog.Warn("tracing is disabled; no spans are recorded or exported")
+	case cfg.InMemorySpans:
+		// Export synchronously so that spans show up in /debug/spans as soon
+		// as they end.
+		spanRecorder = tracetest.NewInMemoryExporter()
+		tp, err = newTracerProvider(res, cfg, sampler, sdktrace.WithSyncer(spanRecorder))
+		slog.Warn("spans are kept in memory and served on /debug/spans instead of being exported")
+	default:
+		health = new(exportHealth)
+		tp, err = setupTracing(ctx, res, cfg, sampler, health)
+	}
+	if err != nil {
+		slog.Error("failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
 
-	otel.SetTracerProvider(tp)
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
+	var hooks shutdownHooks
+	if tp != nil {
+		hooks.register("tracer provider", flushAndShutdown(tp))
+	}
+	hooks.register("meter provider", flushAndShutdown(mp))
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	slog.SetDefault(logger)
+	if cfg.RateLimit > 0 {
+		slog.Info("rate limiting enabled", "rate", cfg.RateLimit, "burst", cfg.RateBurst)
+	}
+	if cfg.BasePath != "" {
+		slog.Info("routes are mounted under a base path", "base_path", cfg.BasePath)
+	}
+	if cfg.EnablePprof {
+		slog.Warn("pprof endpoints are enabled on /debug/pprof/")
+	}
+	if cfg.EnableDebug {
+		slog.Warn("the running configuration is served on /debug/config")
+	}
+	if cfg.RecordRequests {
+		slog.Warn("incoming requests are recorded and served on /debug/requests")
+	}
 
-	srv := &server{
-		tracer: otel.Tracer(""),
+	srv, err := newServer(cfg, logLevel, health, spanRecorder)
+	if err != nil {
+		slog.Error("failed to create server", "error", err)
+		os.Exit(1)
+	}
+	hooks.register("background tasks", srv.waitBackground)
+	srv.startTime = start
+	srv.resource = res
+	adminShutdown := make(chan struct{}, 1)
+	srv.requestShutdown = func() {
+		select {
+		case adminShutdown <- struct{}{}:
+		default:
+		}
 	}
 
 	httpServer := &http.Server{
-		Addr:    ":8080",
-		Handler: srv,
+		Addr:              cfg.Addr,
+		Handler:           srv,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		// net/http answers a bare 431 only once headers exceed this by its
+		// 4KiB allowance; traced handlers reject anything above it
+		// themselves, so the rejection shows up in traces and logs.
+		MaxHeaderBytes: cfg.MaxHeaderBytes,
+		ConnState:      srv.trackConnection,
+	}
+	if cfg.EnableH2C {
+		// Configuring the server lets Shutdown drain HTTP/2 connections too,
+		// which h2c takes over from net/http.
+		h2s := &http2.Server{IdleTimeout: cfg.IdleTimeout}
+		if err := http2.ConfigureServer(httpServer, h2s); err != nil {
+			slog.Error("failed to configure HTTP/2", "error", err)
+			os.Exit(1)
+		}
+		httpServer.Handler = h2c.NewHandler(srv, h2s)
+		slog.Info("serving HTTP/2 over cleartext (h2c)")
 	}
 
+	// SIGHUP re-reads the settings that can change without a restart.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go srv.reloadOnSignal(reload, sampler)
+
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
+
 		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt)
-		<-sigChan
+		signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+		var reason string
+		select {
+		case sig := <-sigChan:
+			reason = sig.String()
+		case <-adminShutdown:
+			reason = "admin request"
+		}
 
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+		// A second signal during a slow drain forces an immediate exit, like
+		// pressing Ctrl-C twice.
+		go forceExitOnSignal(sigChan, os.Exit)
 
-		slog.Info("shutting down server")
-		if err := httpServer.Shutdown(shutdownCtx); err != nil {
-			slog.Error("server shutdown failed", "error", err)
-		}
+		shutdownErr := srv.shutdown(httpServer, cfg, reason)
+
+		// The flushes get a budget of their own: a slow drain may have used up
+		// the shutdown timeout, and the last spans would be dropped with it.
+		hooksErr := hooks.run(cfg.ShutdownTimeout)
+		srv.logShutdownSummary(reason, shutdownErr == nil && hooksErr == nil)
 	}()
 
-	slog.Info("starting server", "addr", httpServer.Addr)
-	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+	ln, err := listen(cfg)
+	if err != nil {
+		slog.Error("failed to listen", "addr", cfg.Addr, "source", cfg.AddrSource, "error", err)
+		os.Exit(1)
+	}
+	srv.ready.Store(true)
+
+	slog.Info("starting server", "addr", httpServer.Addr, "addr_source", cfg.AddrSource, "tls", cfg.TLSCertFile != "")
+	if err := serveListener(httpServer, ln, cfg); err != http.ErrServerClosed {
 		slog.Error("server error", "error", err)
+		// Flush what was recorded before exiting. A shutdown already under
+		// way makes this wait for it instead of flushing again.
+		hooks.run(cfg.ShutdownTimeout)
 		os.Exit(1)
 	}
+	<-shutdownDone
 }
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// testConfig loads the configuration from the environment, which tests set
+// up with t.Setenv beforehand.
+func testConfig(t testing.TB) Config {
+	t.Helper()
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	return cfg
+}
+
+// newTestServer builds a server from cfg whose spans are recorded, as soon as
+// they end, in the returned exporter.
+func newTestServer(t testing.TB, cfg Config, handlers ...Handler) (*server, *tracetest.InMemoryExporter) {
+	t.Helper()
+	sampler, err := newReloadableSampler(cfg.Sampler, cfg.SamplerRatio)
+	if err != nil {
+		t.Fatalf("newReloadableSampler() error = %v", err)
+	}
+	exp := tracetest.NewInMemoryExporter()
+	tp, err := newTracerProvider(resource.Empty(), cfg, sampler, sdktrace.WithSyncer(exp))
+	if err != nil {
+		t.Fatalf("newTracerProvider() error = %v", err)
+	}
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+
+	s, err := newServer(cfg, new(slog.LevelVar), nil, exp, handlers...)
+	if err != nil {
+		t.Fatalf("newServer() error = %v", err)
+	}
+	return s, exp
+}
+
+// serve sends r to s and returns the recorded response.
+func serve(s http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// findSpan returns the first recorded span called name.
+func findSpan(t *testing.T, exp *tracetest.InMemoryExporter, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range exp.GetSpans() {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no %q span among %d recorded spans", name, len(exp.GetSpans()))
+	return tracetest.SpanStub{}
+}
+
+// spanAttr returns the value of the attribute key of span.
+func spanAttr(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	tests := []struct {
+		method, target string
+		wantAllow      string
+	}{
+		{"POST", "/_ah/health", "GET, HEAD"},
+		{"POST", "/echo/hello", "GET, HEAD"},
+		{"DELETE", "/echo", "GET, HEAD, POST"},
+	}
+	for _, tt := range tests {
+		w := serve(s, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s %s status = %d, want %d", tt.method, tt.target, w.Code, http.StatusMethodNotAllowed)
+		}
+		if got := w.Header().Get("Allow"); got != tt.wantAllow {
+			t.Errorf("%s %s Allow = %q, want %q", tt.method, tt.target, got, tt.wantAllow)
+		}
+	}
+
+	if w := serve(s, httptest.NewRequest("GET", "/missing", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET /missing status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// failingWriter is a ResponseWriter whose writes fail with err.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func TestWriteJSONError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus codes.Code
+		wantEvent  string
+	}{
+		{"encode failure", errors.New("write failed"), codes.Error, "exception"},
+		{"client disconnect", syscall.EPIPE, codes.Unset, "client_disconnected"},
+		{"connection reset", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.ECONNRESET)}, codes.Unset, "client_disconnected"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, exp := newTestServer(t, testConfig(t))
+			logs := captureLogs(t)
+			ctx, span := s.tracer.Start(context.Background(), "encode")
+			s.writeJSON(ctx, failingWriter{httptest.NewRecorder(), tt.err}, Response{Message: "hi"})
+			span.End()
+
+			got := findSpan(t, exp, "encode")
+			if got.Status.Code != tt.wantStatus {
+				t.Errorf("span status = %v, want %v", got.Status.Code, tt.wantStatus)
+			}
+			if len(got.Events) != 1 || got.Events[0].Name != tt.wantEvent {
+				t.Errorf("span events = %+v, want a %q event", got.Events, tt.wantEvent)
+			}
+			// A disconnect is only logged at debug level, below the
+			// captured info level.
+			logged := countLogs(logs(), "failed to encode response") > 0
+			if want := tt.wantStatus == codes.Error; logged != want {
+				t.Errorf("logged the encode error = %t, want %t", logged, want)
+			}
+		})
+	}
+}
+
+func TestEchoPathDecoding(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		want       string
+	}{
+		{"/echo/hello%20world", http.StatusOK, "hello world"},
+		{"/echo/a%2Fb", http.StatusOK, "a/b"},
+		{"/echo/%E3%81%93%E3%82%93%E3%81%AB%E3%81%A1%E3%81%AF", http.StatusOK, "こんにちは"},
+		{"/echo/a/b", http.StatusBadRequest, ""},
+		{"/echo?message=from%20query", http.StatusOK, "from query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			s, exp := newTestServer(t, testConfig(t))
+
+			w := serve(s, httptest.NewRequest("GET", tt.target, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.want == "" {
+				return
+			}
+			var resp Response
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if resp.Message != tt.want {
+				t.Errorf("message = %q, want %q", resp.Message, tt.want)
+			}
+			if v, _ := spanAttr(findSpan(t, exp, "echo-handler"), "message"); v.AsString() != tt.want {
+				t.Errorf("span message = %q, want %q", v.AsString(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEchoMessageSource(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantStatus int
+		message    string
+		source     string
+	}{
+		{"/echo?message=query", http.StatusOK, "query", "query"},
+		{"/echo/path", http.StatusOK, "path", "path"},
+		{"/echo/path?message=query", http.StatusOK, "path", "path"},
+		{"/echo", http.StatusBadRequest, "", ""},
+		{"/echo/", http.StatusBadRequest, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			s, exp := newTestServer(t, testConfig(t))
+
+			w := serve(s, httptest.NewRequest("GET", tt.target, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			span := findSpan(t, exp, "echo-handler")
+			if tt.source == "" {
+				if v, _ := spanAttr(span, "message.empty"); !v.AsBool() {
+					t.Error("message.empty not recorded")
+				}
+				return
+			}
+			if v, _ := spanAttr(span, "message.source"); v.AsString() != tt.source {
+				t.Errorf("message.source = %q, want %q", v.AsString(), tt.source)
+			}
+			if v, _ := spanAttr(span, "message"); v.AsString() != tt.message {
+				t.Errorf("message = %q, want %q", v.AsString(), tt.message)
+			}
+		})
+	}
+}
+
+func TestEchoMessageTooLong(t *testing.T) {
+	t.Setenv("MAX_MESSAGE_LENGTH", "8")
+	s, exp := newTestServer(t, testConfig(t))
+
+	if w := serve(s, httptest.NewRequest("GET", "/echo/12345678", nil)); w.Code != http.StatusOK {
+		t.Errorf("message at the limit: status = %d, want %d", w.Code, http.StatusOK)
+	}
+	exp.Reset()
+
+	w := serve(s, httptest.NewRequest("GET", "/echo/123456789", nil))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	span := findSpan(t, exp, "echo-handler")
+	if v, _ := spanAttr(span, "message.length"); v.AsInt64() != 9 {
+		t.Errorf("message.length = %d, want 9", v.AsInt64())
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("span status = %v, want error", span.Status.Code)
+	}
+}
+
+func TestEchoMessageTooLongEveryRoute(t *testing.T) {
+	t.Setenv("MAX_MESSAGE_LENGTH", "8")
+	s, _ := newTestServer(t, testConfig(t))
+	const long = "123456789"
+
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/echo/"+long, nil),
+		httptest.NewRequest("GET", "/echo?message="+long, nil),
+		httptest.NewRequest("POST", "/echo", strings.NewReader(`{"message":"`+long+`"}`)),
+		httptest.NewRequest("GET", "/echo/"+long+"/stream", nil),
+		httptest.NewRequest("GET", "/echo/"+long+"/fail", nil),
+		httptest.NewRequest("GET", "/echo/"+long+"/nested", nil),
+		httptest.NewRequest("GET", "/echo/"+long+"/enriched", nil),
+		httptest.NewRequest("POST", "/echo/"+long+"/background", nil),
+		httptest.NewRequest("POST", "/echo/batch", strings.NewReader(`[{"message":"ok"},{"message":"`+long+`"}]`)),
+		httptest.NewRequest("PUT", "/store/k", strings.NewReader(`{"message":"`+long+`"}`)),
+	} {
+		if w := serve(s, r); w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s %s status = %d, want %d", r.Method, r.URL, w.Code, http.StatusRequestEntityTooLarge)
+		}
+	}
+}
+
+func TestEchoSpanEvents(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	var names []string
+	for _, e := range findSpan(t, exp, "echo-handler").Events {
+		names = append(names, e.Name)
+	}
+	want := []string{"request received", "processing started", "response written"}
+	if !slices.Equal(names, want) {
+		t.Errorf("events = %q, want %q", names, want)
+	}
+}
+
+func TestEchoClientDisconnect(t *testing.T) {
+	t.Setenv("ECHO_DELAY", "10s")
+	s, exp := newTestServer(t, testConfig(t))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handler returned after %s, want it to stop when the client goes away", elapsed)
+	}
+	span := findSpan(t, exp, "echo-handler")
+	if !slices.ContainsFunc(span.Events, func(e sdktrace.Event) bool { return e.Name == "client_disconnected" }) {
+		t.Errorf("span events = %+v, want client_disconnected", span.Events)
+	}
+}
+
+func TestEchoOverH2C(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	srv := httptest.NewServer(h2c.NewHandler(s, &http2.Server{}))
+	defer srv.Close()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	resp, err := client.Get(srv.URL + "/echo/hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ProtoMajor != 2 {
+		t.Errorf("protocol = %s, want HTTP/2", resp.Proto)
+	}
+	var body Response
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Message != "hi" {
+		t.Errorf("response = %+v (%v), want the echoed message", body, err)
+	}
+	if v, _ := spanAttr(findSpan(t, exp, "echo-handler"), "message"); v.AsString() != "hi" {
+		t.Errorf("span message = %q, want hi", v.AsString())
+	}
+}
+
+func TestListenAddressInUse(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	cfg := testConfig(t)
+	cfg.Addr, cfg.AddrSource = taken.Addr().String(), "PORT"
+
+	ln, err := listen(cfg)
+
+	if err == nil {
+		ln.Close()
+		t.Fatal("listen() error = nil, want the address reported as in use")
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("listen() error = %v, want EADDRINUSE", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, cfg.Addr) || !strings.Contains(msg, "set PORT") {
+		t.Errorf("listen() error = %q, want the address and how to pick another", msg)
+	}
+}
+
+func TestHealthPath(t *testing.T) {
+	t.Setenv("HEALTH_PATH", "/ready")
+	s, _ := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("GET", "/ready", nil))
+
+	var health HealthResponse
+	if err := json.NewDecoder(w.Body).Decode(&health); w.Code != http.StatusOK || err != nil || health.Status == "" {
+		t.Errorf("GET /ready = %d %+v (%v), want the health report", w.Code, health, err)
+	}
+	if w := serve(s, httptest.NewRequest("GET", defaultHealthPath, nil)); w.Code != http.StatusNotFound {
+		t.Errorf("GET %s status = %d, want %d", defaultHealthPath, w.Code, http.StatusNotFound)
+	}
+}
+
+func TestEchoJSONEncoding(t *testing.T) {
+	tests := []struct {
+		prettyJSON string
+		want       string
+	}{
+		{"", `{"message":"\u003cb\u003e"}` + "\n"},
+		{"true", "{\n  \"message\": \"<b>\"\n}\n"},
+	}
+	for _, tt := range tests {
+		t.Run("PRETTY_JSON="+tt.prettyJSON, func(t *testing.T) {
+			t.Setenv("PRETTY_JSON", tt.prettyJSON)
+			s, _ := newTestServer(t, testConfig(t))
+
+			w := serve(s, httptest.NewRequest("GET", "/echo?message=%3Cb%3E", nil))
+
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEchoResponseFieldName(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"", `{"message":"hi"}` + "\n"},
+		{"echo", `{"echo":"hi"}` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run("RESPONSE_FIELD_NAME="+tt.field, func(t *testing.T) {
+			t.Setenv("RESPONSE_FIELD_NAME", tt.field)
+			s, _ := newTestServer(t, testConfig(t))
+
+			for _, r := range []*http.Request{
+				httptest.NewRequest("GET", "/echo/hi", nil),
+				httptest.NewRequest("POST", "/echo", strings.NewReader(`{"message":"hi"}`)),
+			} {
+				w := serve(s, r)
+				if got := w.Body.String(); got != tt.want {
+					t.Errorf("%s %s body = %q, want %q", r.Method, r.URL, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEchoResponseFieldNameEveryRoute(t *testing.T) {
+	for _, field := range []string{"", "echo"} {
+		t.Run("RESPONSE_FIELD_NAME="+field, func(t *testing.T) {
+			t.Setenv("RESPONSE_FIELD_NAME", field)
+			s, _ := newTestServer(t, testConfig(t))
+			want := field
+			if want == "" {
+				want = "message"
+			}
+
+			for _, tt := range []struct {
+				r     *http.Request
+				batch bool
+			}{
+				{r: httptest.NewRequest("POST", "/echo/hi/background", nil)},
+				{r: httptest.NewRequest("GET", "/echo/hi/enriched", nil)},
+				{r: httptest.NewRequest("GET", "/echo/hi/nested", nil)},
+				{r: httptest.NewRequest("POST", "/echo/batch", strings.NewReader(`[{"message":"hi"}]`)), batch: true},
+				{r: httptest.NewRequest("PUT", "/store/k", strings.NewReader(`{"message":"hi"}`))},
+				{r: httptest.NewRequest("GET", "/store/k", nil)},
+			} {
+				w := serve(s, tt.r)
+				body := w.Body.Bytes()
+				if tt.batch {
+					var items []json.RawMessage
+					if err := json.Unmarshal(body, &items); err != nil || len(items) != 1 {
+						t.Fatalf("%s %s body = %q, want one item", tt.r.Method, tt.r.URL, body)
+					}
+					body = items[0]
+				}
+				var got map[string]any
+				if err := json.Unmarshal(body, &got); err != nil {
+					t.Fatalf("%s %s body = %q: %v", tt.r.Method, tt.r.URL, body, err)
+				}
+				if got[want] != "hi" {
+					t.Errorf("%s %s body = %q, want %q field %q", tt.r.Method, tt.r.URL, body, want, "hi")
+				}
+				if _, ok := got["message"]; ok && want != "message" {
+					t.Errorf("%s %s body = %q, still has a message field", tt.r.Method, tt.r.URL, body)
+				}
+			}
+		})
+	}
+}
+
+func TestEchoEmptyMessage(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	tests := []struct {
+		target     string
+		wantStatus int
+		wantError  string
+	}{
+		{"/echo", http.StatusBadRequest, "message is required: use /echo/{message} or /echo?message="},
+		{"/echo/", http.StatusBadRequest, "message is required: use /echo/{message} or /echo?message="},
+		{"/echo?message=", http.StatusBadRequest, "message is required: use /echo/{message} or /echo?message="},
+		{"/missing", http.StatusNotFound, http.StatusText(http.StatusNotFound)},
+	}
+	for _, tt := range tests {
+		w := serve(s, httptest.NewRequest("GET", tt.target, nil))
+
+		var resp ErrorResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("GET %s: decode response: %v", tt.target, err)
+		}
+		if w.Code != tt.wantStatus || resp.Error != tt.wantError {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.target, w.Code, resp.Error, tt.wantStatus, tt.wantError)
+		}
+	}
+}
+
+func TestListenMaxConnections(t *testing.T) {
+	t.Setenv("MAX_CONNECTIONS", "1")
+	cfg := testConfig(t)
+	cfg.Addr = "127.0.0.1:0"
+	ln, err := listen(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	// get sends a request on conn and reports whether the response arrived
+	// within wait.
+	get := func(conn net.Conn, wait time.Duration) bool {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := conn.Read(make([]byte, 1))
+		return err == nil
+	}
+	first, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !get(first, time.Second) {
+		t.Fatal("first connection got no response")
+	}
+	second, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	if get(second, 100*time.Millisecond) {
+		t.Fatal("second connection was served while the first one was open")
+	}
+	first.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != nil {
+		t.Errorf("second connection after the first one closed: %v, want it served", err)
+	}
+}
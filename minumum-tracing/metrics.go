@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type metrics struct {
+	requests *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+	handler  http.Handler
+}
+
+func newMetrics(reg *prometheus.Registry) *metrics {
+	m := &metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Number of HTTP requests by route and status.",
+		}, []string{"route", "status"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+		// Exemplars are only exposed in the OpenMetrics format, which
+		// scrapers have to ask for.
+		handler: promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	}
+	reg.MustRegister(m.requests, m.latency)
+	return m
+}
+
+// observe records a request. When sc is a sampled span context, the latency
+// observation carries its trace ID as an exemplar so that backends can link
+// the bucket to an example trace.
+func (m *metrics) observe(route string, status int, d time.Duration, sc trace.SpanContext) {
+	m.requests.WithLabelValues(route, strconv.Itoa(status)).Inc()
+	latency := m.latency.WithLabelValues(route)
+	if !sc.IsSampled() {
+		latency.Observe(d.Seconds())
+		return
+	}
+	latency.(prometheus.ExemplarObserver).ObserveWithExemplar(d.Seconds(), prometheus.Labels{
+		"trace_id": sc.TraceID().String(),
+	})
+}
+
+// middleware records request counts and latency for each route.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, slot := withServerSpanSlot(r.Context())
+		rec := newStatusRecorder(w)
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		m.observe(routeFromContext(ctx), rec.status, time.Since(start), slot.span)
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// latencyExemplars returns the trace IDs of the exemplars recorded in the
+// latency histogram of s.
+func latencyExemplars(t *testing.T, s *server) []string {
+	t.Helper()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(s.metrics.latency)
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	var traceIDs []string
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			for _, b := range m.GetHistogram().GetBucket() {
+				for _, l := range b.GetExemplar().GetLabel() {
+					if l.GetName() == "trace_id" {
+						traceIDs = append(traceIDs, l.GetValue())
+					}
+				}
+			}
+		}
+	}
+	return traceIDs
+}
+
+func TestLatencyExemplar(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	traceID := findSpan(t, exp, "echo-handler").SpanContext.TraceID().String()
+	if got := latencyExemplars(t, s); len(got) != 1 || got[0] != traceID {
+		t.Errorf("exemplar trace IDs = %q, want the request's trace %s", got, traceID)
+	}
+
+	r := httptest.NewRequest("GET", "/metrics", nil)
+	r.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+	if body := serve(s, r).Body.String(); !strings.Contains(body, `trace_id="`+traceID+`"`) {
+		t.Errorf("/metrics in OpenMetrics format lacks the exemplar for trace %s", traceID)
+	}
+}
+
+func TestLatencyExemplarUnsampled(t *testing.T) {
+	t.Setenv("OTEL_TRACES_SAMPLER", "traceidratio")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0")
+	s, _ := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	if got := latencyExemplars(t, s); len(got) != 0 {
+		t.Errorf("exemplar trace IDs = %q, want none for an unsampled request", got)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware wraps an http.Handler with a cross-cutting concern.
+type Middleware func(http.Handler) http.Handler
+
+// chain applies mws to h so that the first middleware is the outermost one.
+func chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+type routeKey struct{}
+
+// routeTemplate stores the matched route template in the request context.
+func routeTemplate(route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeKey{}, route)))
+		})
+	}
+}
+
+// routeFromContext returns the route template matched for the request.
+func routeFromContext(ctx context.Context) string {
+	route, _ := ctx.Value(routeKey{}).(string)
+	return route
+}
+
+type serverSpanKey struct{}
+
+// serverSpan is the span context of the request's server span, and that of
+// its parent when the request continued a trace.
+type serverSpan struct {
+	span   trace.SpanContext
+	parent trace.SpanContext
+}
+
+// withServerSpanSlot returns a context holding a slot that the tracing
+// middleware fills with the server span context. Middlewares running outside
+// of tracing read it after the handler returns, since the span is not in
+// their request context. A slot already in ctx is shared.
+func withServerSpanSlot(ctx context.Context) (context.Context, *serverSpan) {
+	if slot, ok := ctx.Value(serverSpanKey{}).(*serverSpan); ok {
+		return ctx, slot
+	}
+	slot := new(serverSpan)
+	return context.WithValue(ctx, serverSpanKey{}, slot), slot
+}
+
+// setServerSpan stores the span contexts in the slot of ctx, if any.
+func setServerSpan(ctx context.Context, span, parent trace.SpanContext) {
+	if slot, ok := ctx.Value(serverSpanKey{}).(*serverSpan); ok {
+		*slot = serverSpan{span: span, parent: parent}
+	}
+}
+
+const requestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// requestIDFromContext returns the request ID assigned by the requestID
+// middleware.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestID reuses the caller's X-Request-Id or generates a new one, and
+// echoes it back in the response.
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = uuid.NewString()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// maxLoggedBaggageMembers caps how many baggage members are added to logs so
+// that a large upstream baggage cannot blow up every log line.
+const maxLoggedBaggageMembers = 16
+
+type baggageAttrsKey struct{}
+
+// baggageAttrsFromContext returns the log attributes derived from the
+// request baggage by the baggageLogging middleware.
+func baggageAttrsFromContext(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(baggageAttrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// baggageLogging extracts the incoming baggage and attaches its members to
+// every log line of the request as baggage.<key> attributes.
+func baggageLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.Baggage{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		members := baggage.FromContext(ctx).Members()
+		if len(members) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sort.Slice(members, func(i, j int) bool { return members[i].Key() < members[j].Key() })
+		if len(members) > maxLoggedBaggageMembers {
+			members = members[:maxLoggedBaggageMembers]
+		}
+		attrs := make([]slog.Attr, 0, len(members))
+		for _, m := range members {
+			attrs = append(attrs, slog.String("baggage."+m.Key(), m.Value()))
+		}
+
+		ctx = context.WithValue(ctx, baggageAttrsKey{}, attrs)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tracing starts a server span with the given name around the handler,
+// continuing any trace context carried by the incoming request.
+// X-Force-Trace: true or ?trace=1 samples the request regardless of the
+// sampler, and ?trace=0 drops it unless forced by the header. The route
+// template is set when the span starts, so samplers can act on it and
+// backends can group spans by route rather than by concrete path.
+func tracing(tracer trace.Tracer, spanName string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			forced, _ := strconv.ParseBool(r.Header.Get(forceTraceHeader))
+			switch r.URL.Query().Get(traceQueryParam) {
+			case "1":
+				forced = true
+			case "0":
+				ctx = withSuppressTrace(ctx)
+			}
+			if forced {
+				ctx = withForceTrace(ctx)
+			}
+			parent := trace.SpanContextFromContext(ctx)
+			ctx, span := tracer.Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.route", routeFromContext(ctx)),
+				attribute.String("http.scheme", requestScheme(r)),
+				attribute.String("http.user_agent", r.UserAgent()),
+				attribute.String("net.peer.ip", originalClientIP(r)),
+			))
+			defer span.End()
+			setServerSpan(ctx, span.SpanContext(), parent)
+
+			rec := newStatusRecorder(w)
+			start := time.Now()
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			span.SetAttributes(
+				attribute.Int("http.status_code", rec.status),
+				attribute.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			)
+			rec.setSpanStatus(span)
+		})
+	}
+}
+
+// checkTraceparent flags requests whose traceparent header is present but
+// malformed. The propagator silently ignores it, typically starting a new
+// trace, which would otherwise hide broken instrumentation upstream. The
+// header is only checked when the tracecontext propagator is in use.
+func checkTraceparent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("traceparent")
+		if header != "" && slices.Contains(otel.GetTextMapPropagator().Fields(), "traceparent") {
+			carrier := propagation.HeaderCarrier(r.Header)
+			valid := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier)).IsValid()
+			if !valid {
+				ctx := r.Context()
+				trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("invalid_traceparent", true))
+				slog.WarnContext(ctx, "ignoring malformed traceparent header", "traceparent", header)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkTraceHeaders flags requests whose traceparent and X-Cloud-Trace-Context
+// headers name different traces. parsePropagators makes traceparent win, so
+// the span continues the W3C trace; the conflict is recorded so that the
+// caller sending inconsistent headers can be found. It only applies when
+// both propagators are in use.
+func checkTraceHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := otel.GetTextMapPropagator().Fields()
+		inUse := slices.Contains(fields, "traceparent") && slices.Contains(fields, cloudTraceContextHeader)
+		if inUse && r.Header.Get(cloudTraceContextHeader) != "" {
+			carrier := propagation.HeaderCarrier(r.Header)
+			w3c := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+			gcp, err := parseCloudTraceContext(r.Header.Get(cloudTraceContextHeader))
+			if err == nil && w3c.IsValid() && w3c.TraceID() != gcp.TraceID() {
+				ctx := r.Context()
+				trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("trace_header_conflict", true))
+				slog.WarnContext(ctx, "conflicting trace headers, continuing the traceparent trace",
+					"traceparent_trace_id", w3c.TraceID().String(),
+					"cloud_trace_id", gcp.TraceID().String(),
+				)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// defaultTraceIDHeader is the response header carrying the trace ID unless
+// TRACE_ID_HEADER names another one.
+const defaultTraceIDHeader = "X-Trace-Id"
+
+// traceSampledHeader tells clients whether the trace of their request was
+// sampled, and so whether it should show up in the trace backend.
+const traceSampledHeader = "X-Trace-Sampled"
+
+// traceIDResponse sets the header named name to the trace ID of the active
+// span before the handler writes anything, so that clients can quote it when
+// reporting a problem. It also sets X-Trace-Sampled unless the header is
+// already present.
+func traceIDResponse(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sc := trace.SpanContextFromContext(r.Context())
+			if sc.HasTraceID() {
+				w.Header().Set(name, sc.TraceID().String())
+				if w.Header().Get(traceSampledHeader) == "" {
+					w.Header().Set(traceSampledHeader, strconv.FormatBool(sc.IsSampled()))
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requestScheme returns the scheme the client used. TLS is terminated by the
+// App Engine front end, which reports the original scheme in
+// X-Forwarded-Proto.
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		first, _, _ := strings.Cut(proto, ",")
+		return strings.ToLower(strings.TrimSpace(first))
+	}
+	return "http"
+}
+
+// contentLength records how many bytes were read from the request body and
+// written to the response on the active span.
+func contentLength(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rec := newStatusRecorder(w)
+
+		next.ServeHTTP(rec, r)
+
+		trace.SpanFromContext(r.Context()).SetAttributes(
+			attribute.Int64("http.request_content_length", body.n),
+			attribute.Int64("http.response_content_length", rec.written),
+		)
+	})
+}
+
+// bodyLimit rejects requests declaring a Content-Length above limit with 413
+// before the handler runs, and caps the body of the others, such as chunked
+// uploads, at limit.
+func bodyLimit(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				ctx := r.Context()
+				trace.SpanFromContext(ctx).AddEvent("request_body_too_large", trace.WithAttributes(
+					attribute.Int64("http.request_content_length", r.ContentLength),
+					attribute.Int64("limit", limit),
+				))
+				writeJSONError(w, http.StatusRequestEntityTooLarge,
+					fmt.Sprintf("request body exceeds %d bytes", limit), traceIDFromContext(ctx))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// headerLimit rejects requests whose headers, counted as they appear on the
+// wire, exceed limit bytes with 431. A zero limit disables the check.
+func headerLimit(limit int) Middleware {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			size := 0
+			for name, values := range r.Header {
+				for _, v := range values {
+					// name: value\r\n
+					size += len(name) + len(v) + 4
+				}
+			}
+			if size <= limit {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			trace.SpanFromContext(ctx).AddEvent("request_headers_too_large", trace.WithAttributes(
+				attribute.Int("http.request_header_size", size),
+				attribute.Int("limit", limit),
+			))
+			slog.WarnContext(ctx, "request headers too large", "size", size, "limit", limit)
+			writeJSONError(w, http.StatusRequestHeaderFieldsTooLarge,
+				fmt.Sprintf("request headers exceed %d bytes", limit), traceIDFromContext(ctx))
+		})
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sloCounters count traced requests against the SLO latency threshold.
+type sloCounters struct {
+	within    metric.Int64Counter
+	exceeding metric.Int64Counter
+}
+
+func newSLOCounters(meter metric.Meter) (*sloCounters, error) {
+	within, err := meter.Int64Counter("requests_within_slo",
+		metric.WithDescription("Number of requests completed within the SLOW_THRESHOLD latency."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	exceeding, err := meter.Int64Counter("requests_exceeding_slo",
+		metric.WithDescription("Number of requests slower than the SLOW_THRESHOLD latency."),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &sloCounters{within: within, exceeding: exceeding}, nil
+}
+
+// slowRequests counts every request as within or exceeding the SLO
+// threshold, and for slow ones adds a slow_request event to the active span
+// and logs a warning, even if the request succeeds. threshold holds
+// nanoseconds and is read on every request so that it can change at runtime;
+// zero disables the check.
+func slowRequests(threshold *atomic.Int64, slo *sloCounters) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			threshold := time.Duration(threshold.Load())
+			if threshold <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			next.ServeHTTP(w, r)
+
+			elapsed := time.Since(start)
+			ctx := r.Context()
+			route := metric.WithAttributes(attribute.String("http.route", routeFromContext(ctx)))
+			if elapsed <= threshold {
+				slo.within.Add(ctx, 1, route)
+				return
+			}
+			slo.exceeding.Add(ctx, 1, route)
+			trace.SpanFromContext(ctx).AddEvent("slow_request", trace.WithAttributes(
+				attribute.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+				attribute.Float64("threshold_ms", float64(threshold.Microseconds())/1000),
+			))
+			slog.WarnContext(ctx, "slow request",
+				"route", routeFromContext(ctx),
+				"elapsed", elapsed.String(),
+				"threshold", threshold.String(),
+			)
+		})
+	}
+}
+
+// recovery turns a handler panic into a 500 response, or aborts the response
+// if the handler had already started it, and records the panic on the active
+// span.
+func recovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newStatusRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			ctx := r.Context()
+			err := fmt.Errorf("panic: %v", v)
+			span := trace.SpanFromContext(ctx)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			slog.ErrorContext(ctx, "recovered from panic", "error", err, "stack", string(debug.Stack()))
+
+			if rec.wroteHeader {
+				// Part of the response is already sent, so a 500 can no longer
+				// be; abort it for the client to see it fail instead of
+				// receiving an error body appended to a truncated one.
+				panic(http.ErrAbortHandler)
+			}
+			writeJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), traceIDFromContext(ctx))
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+	// wroteHeader is set once the status line has been sent.
+	wroteHeader bool
+}
+
+func newStatusRecorder(w http.ResponseWriter) *statusRecorder {
+	return &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	// Informational responses are followed by the final one.
+	if code >= http.StatusOK {
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(p []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(p)
+	rec.written += int64(n)
+	return n, err
+}
+
+// setSpanStatus marks span as failed when the response is a 4xx or 5xx,
+// unless the handler already set a status with a more specific description.
+// Successful responses leave the status unset, as OTel recommends.
+func (rec *statusRecorder) setSpanStatus(span trace.Span) {
+	if rec.status < http.StatusBadRequest {
+		return
+	}
+	if ro, ok := span.(sdktrace.ReadOnlySpan); ok && ro.Status().Code != codes.Unset {
+		return
+	}
+	span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d %s", rec.status, http.StatusText(rec.status)))
+}
+
+// Hijack hands the connection over to the handler, e.g. for a WebSocket
+// upgrade, which some libraries detect with a plain type assertion.
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rec.ResponseWriter).Hijack()
+	if err == nil {
+		rec.status = http.StatusSwitchingProtocols
+		rec.wroteHeader = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streaming responses.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
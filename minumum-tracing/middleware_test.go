@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingContinuesIncomingTrace(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	serve(s, r)
+
+	span := findSpan(t, exp, "echo-handler")
+	if got, want := span.SpanContext.TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736"; got != want {
+		t.Errorf("trace ID = %s, want %s", got, want)
+	}
+	if got, want := span.Parent.SpanID().String(), "00f067aa0ba902b7"; got != want {
+		t.Errorf("parent span ID = %s, want %s", got, want)
+	}
+	if !span.Parent.IsRemote() {
+		t.Error("parent span context is not remote")
+	}
+}
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name+" before")
+				next.ServeHTTP(w, r)
+				order = append(order, name+" after")
+			})
+		}
+	}
+	h := chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		order = append(order, "handler")
+	}), record("outer"), record("inner"))
+
+	serve(h, httptest.NewRequest("GET", "/", nil))
+
+	want := []string{"outer before", "inner before", "handler", "inner after", "outer after"}
+	if !slices.Equal(order, want) {
+		t.Errorf("order = %q, want %q", order, want)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	h := chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}), tracing(s.tracer, "panic-handler"), recovery)
+
+	w := serve(h, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	span := findSpan(t, exp, "panic-handler")
+	if body.TraceID != span.SpanContext.TraceID().String() {
+		t.Errorf("error trace_id = %q, want %s", body.TraceID, span.SpanContext.TraceID())
+	}
+	if span.Status.Code != codes.Error || !strings.Contains(span.Status.Description, "boom") {
+		t.Errorf("span status = %+v, want an error mentioning the panic", span.Status)
+	}
+	if len(span.Events) == 0 || span.Events[0].Name != "exception" {
+		t.Errorf("span events = %+v, want the recorded panic", span.Events)
+	}
+}
+
+func TestRecoveryAfterResponseStarted(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("partial"))
+		panic("boom")
+	}), tracing(s.tracer, "panic-handler"), recovery)
+
+	w := httptest.NewRecorder()
+	func() {
+		defer func() {
+			if v := recover(); v != http.ErrAbortHandler {
+				t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+			}
+		}()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}()
+
+	if got := w.Body.String(); got != "partial" {
+		t.Errorf("body = %q, want only the partial response", got)
+	}
+	if span := findSpan(t, exp, "panic-handler"); span.Status.Code != codes.Error {
+		t.Errorf("span status = %+v, want an error", span.Status)
+	}
+}
+
+func TestContentLength(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	body := `{"message":"hello"}`
+
+	w := serve(s, httptest.NewRequest("POST", "/echo", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	span := findSpan(t, exp, "echo-handler")
+	if v, _ := spanAttr(span, "http.request_content_length"); v.AsInt64() != int64(len(body)) {
+		t.Errorf("http.request_content_length = %d, want %d", v.AsInt64(), len(body))
+	}
+	if v, _ := spanAttr(span, "http.response_content_length"); v.AsInt64() != int64(w.Body.Len()) {
+		t.Errorf("http.response_content_length = %d, want %d", v.AsInt64(), w.Body.Len())
+	}
+}
+
+func TestSlowRequests(t *testing.T) {
+	t.Setenv("SLOW_THRESHOLD", "1ms")
+	t.Setenv("ECHO_DELAY", "10ms")
+	s, exp := newTestServer(t, testConfig(t))
+
+	if w := serve(s, httptest.NewRequest("GET", "/echo/hi", nil)); w.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	span := findSpan(t, exp, "echo-handler")
+	if !slices.ContainsFunc(span.Events, func(e sdktrace.Event) bool { return e.Name == "slow_request" }) {
+		t.Errorf("span events = %+v, want slow_request", span.Events)
+	}
+}
+
+func TestSlowRequestsWithinThreshold(t *testing.T) {
+	t.Setenv("SLOW_THRESHOLD", "1m")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	span := findSpan(t, exp, "echo-handler")
+	if slices.ContainsFunc(span.Events, func(e sdktrace.Event) bool { return e.Name == "slow_request" }) {
+		t.Error("slow_request recorded for a fast request")
+	}
+}
+
+func TestTracingClientAttributes(t *testing.T) {
+	// net.peer.ip is the original client whatever TRUSTED_PROXY_HOPS, which
+	// only decides the address rate limits apply to.
+	for _, hops := range []string{"0", "1", "2"} {
+		t.Run("hops "+hops, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXY_HOPS", hops)
+			s, exp := newTestServer(t, testConfig(t))
+			r := httptest.NewRequest("GET", "/echo/hi", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set("X-Forwarded-For", "198.51.100.1, 203.0.113.7, 169.254.1.1")
+			r.Header.Set("X-Forwarded-Proto", "https")
+			r.Header.Set("User-Agent", "test-agent")
+
+			serve(s, r)
+
+			span := findSpan(t, exp, "echo-handler")
+			want := map[attribute.Key]string{
+				"net.peer.ip":     "198.51.100.1",
+				"http.scheme":     "https",
+				"http.user_agent": "test-agent",
+			}
+			for k, v := range want {
+				if got, _ := spanAttr(span, k); got.AsString() != v {
+					t.Errorf("%s = %q, want %q", k, got.AsString(), v)
+				}
+			}
+		})
+	}
+}
+
+func TestTracingSpanStatus(t *testing.T) {
+	tests := []struct {
+		target      string
+		wantCode    codes.Code
+		description string
+	}{
+		{"/echo/hi", codes.Unset, ""},
+		{"/echo/a/b", codes.Error, "HTTP 400 Bad Request"},
+	}
+	for _, tt := range tests {
+		s, exp := newTestServer(t, testConfig(t))
+
+		serve(s, httptest.NewRequest("GET", tt.target, nil))
+
+		status := findSpan(t, exp, "echo-handler").Status
+		if status.Code != tt.wantCode || status.Description != tt.description {
+			t.Errorf("GET %s: span status = %+v, want %v %q", tt.target, status, tt.wantCode, tt.description)
+		}
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "16")
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("POST", "/echo", strings.NewReader(`{"message":"too long for the limit"}`)))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+	span := findSpan(t, exp, "echo-handler")
+	if !slices.ContainsFunc(span.Events, func(e sdktrace.Event) bool { return e.Name == "request_body_too_large" }) {
+		t.Errorf("span events = %+v, want request_body_too_large", span.Events)
+	}
+}
+
+func TestBodyLimitChunked(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "16")
+	s, _ := newTestServer(t, testConfig(t))
+	r := httptest.NewRequest("POST", "/echo", strings.NewReader(`{"message":"too long for the limit"}`))
+	r.ContentLength = -1
+
+	if w := serve(s, r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestSLOCounters(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	slo, err := newSLOCounters(mp.Meter("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var threshold atomic.Int64
+	threshold.Store(int64(5 * time.Millisecond))
+	h := chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}), slowRequests(&threshold, slo))
+
+	serve(h, httptest.NewRequest("GET", "/fast", nil))
+	serve(h, httptest.NewRequest("GET", "/fast", nil))
+	serve(h, httptest.NewRequest("GET", "/slow", nil))
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				got[m.Name] += dp.Value
+			}
+		}
+	}
+	if got["requests_within_slo"] != 2 || got["requests_exceeding_slo"] != 1 {
+		t.Errorf("counters = %v, want 2 within and 1 exceeding the SLO", got)
+	}
+}
+
+func TestTracingRouteTemplate(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	var routes []string
+	for _, target := range []string{"/echo/foo", "/echo/bar", "/echo/foo/stream"} {
+		exp.Reset()
+		serve(s, httptest.NewRequest("GET", target, nil))
+		v, _ := spanAttr(findSpan(t, exp, "echo-handler"), "http.route")
+		routes = append(routes, v.AsString())
+	}
+
+	want := []string{"/echo/{message}", "/echo/{message}", "/echo/{message}/stream"}
+	if !slices.Equal(routes, want) {
+		t.Errorf("http.route = %q, want %q", routes, want)
+	}
+}
+
+func TestTraceIDResponseHeader(t *testing.T) {
+	tests := []struct {
+		env, header string
+	}{
+		{"", defaultTraceIDHeader},
+		{"x-correlation-id", "X-Correlation-Id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			t.Setenv("TRACE_ID_HEADER", tt.env)
+			s, exp := newTestServer(t, testConfig(t))
+
+			w := serve(s, httptest.NewRequest("GET", "/echo/hi/stream", nil))
+
+			// Result holds the headers as they were when the response
+			// started, so this checks they were set before the body.
+			got := w.Result().Header
+			want := findSpan(t, exp, "echo-handler").SpanContext.TraceID().String()
+			if got.Get(tt.header) != want {
+				t.Errorf("%s = %q, want the span's trace ID %q", tt.header, got.Get(tt.header), want)
+			}
+			if got.Get(traceSampledHeader) != "true" {
+				t.Errorf("%s = %q, want true", traceSampledHeader, got.Get(traceSampledHeader))
+			}
+		})
+	}
+}
+
+func TestCheckTraceparent(t *testing.T) {
+	tests := []struct {
+		traceparent string
+		wantInvalid bool
+	}{
+		{"garbage", true},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.traceparent, func(t *testing.T) {
+			s, exp := newTestServer(t, testConfig(t))
+			logs := captureLogs(t)
+			r := httptest.NewRequest("GET", "/echo/hi", nil)
+			r.Header.Set("traceparent", tt.traceparent)
+
+			if w := serve(s, r); w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want the request served anyway", w.Code)
+			}
+
+			span := findSpan(t, exp, "echo-handler")
+			if v, _ := spanAttr(span, "invalid_traceparent"); v.AsBool() != tt.wantInvalid {
+				t.Errorf("invalid_traceparent = %t, want %t", v.AsBool(), tt.wantInvalid)
+			}
+			warned := slices.ContainsFunc(logs(), func(e map[string]any) bool { return e["msg"] == "ignoring malformed traceparent header" })
+			if warned != tt.wantInvalid {
+				t.Errorf("warning logged = %t, want %t", warned, tt.wantInvalid)
+			}
+			if tt.wantInvalid && span.Parent.IsValid() {
+				t.Errorf("span parent = %s, want a new trace", span.Parent.SpanID())
+			}
+		})
+	}
+}
+
+func TestHeaderLimit(t *testing.T) {
+	t.Setenv("MAX_HEADER_BYTES", "1024")
+	s, exp := newTestServer(t, testConfig(t))
+	logs := captureLogs(t)
+
+	if w := serve(s, httptest.NewRequest("GET", "/echo/hi", nil)); w.Code != http.StatusOK {
+		t.Fatalf("status = %d within the limit, want %d", w.Code, http.StatusOK)
+	}
+
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("X-Padding", strings.Repeat("a", 2048))
+	w := serve(s, r)
+
+	if w.Code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusRequestHeaderFieldsTooLarge)
+	}
+	spans := exp.GetSpans()
+	events := spans[len(spans)-1].Events
+	if !slices.ContainsFunc(events, func(e sdktrace.Event) bool { return e.Name == "request_headers_too_large" }) {
+		t.Errorf("span events = %v, want request_headers_too_large", events)
+	}
+	if entry := findLog(t, logs(), "request headers too large"); entry["limit"] != 1024.0 {
+		t.Errorf("limit = %v, want 1024", entry["limit"])
+	}
+}
+
+func TestTraceSampledHeader(t *testing.T) {
+	tests := []struct {
+		ratio, want string
+	}{
+		{"1", "true"},
+		{"0", "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ratio, func(t *testing.T) {
+			t.Setenv("OTEL_TRACES_SAMPLER", "traceidratio")
+			t.Setenv("OTEL_TRACES_SAMPLER_ARG", tt.ratio)
+			s, exp := newTestServer(t, testConfig(t))
+
+			w := serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+			if got := w.Header().Get(traceSampledHeader); got != tt.want {
+				t.Errorf("%s = %q, want %s", traceSampledHeader, got, tt.want)
+			}
+			if sampled := len(exp.GetSpans()) > 0; strconv.FormatBool(sampled) != tt.want {
+				t.Errorf("span recorded = %t, want the header to match", sampled)
+			}
+		})
+	}
+}
+
+func TestTraceSampledHeaderAlreadySet(t *testing.T) {
+	h := traceIDResponse(defaultTraceIDHeader)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(trace.ContextWithSpanContext(r.Context(), testSpanContext))
+	w := httptest.NewRecorder()
+	w.Header().Set(traceSampledHeader, "upstream")
+
+	h.ServeHTTP(w, r)
+
+	if got := w.Header().Get(traceSampledHeader); got != "upstream" {
+		t.Errorf("%s = %q, want the header already present kept", traceSampledHeader, got)
+	}
+}
+
+func TestTracingSpanKind(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	for _, target := range []string{"/echo/hi", "/echo/hi/nested", "/version"} {
+		serve(s, httptest.NewRequest("GET", target, nil))
+	}
+
+	for _, span := range exp.GetSpans() {
+		want := trace.SpanKindInternal
+		if !span.Parent.IsValid() {
+			// The request spans started by the tracing middleware.
+			want = trace.SpanKindServer
+		}
+		if span.SpanKind != want {
+			t.Errorf("%s span kind = %v, want %v", span.Name, span.SpanKind, want)
+		}
+	}
+	if span := findSpan(t, exp, "echo-handler"); span.SpanKind != trace.SpanKindServer {
+		t.Errorf("echo-handler span kind = %v, want server", span.SpanKind)
+	}
+}
+
+func TestCheckTraceHeaders(t *testing.T) {
+	const (
+		w3cTraceID   = "4bf92f3577b34da6a3ce929d0e0e4736"
+		cloudTraceID = "105445aa7843bc8bf206b12000100000"
+	)
+	tests := []struct {
+		propagators, cloudTrace string
+		wantConflict            bool
+	}{
+		{"tracecontext,gcp", cloudTraceID + "/1;o=1", true},
+		{"gcp,tracecontext", cloudTraceID + "/1;o=1", true},
+		{"tracecontext,gcp", w3cTraceID + "/1;o=1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.propagators+" "+tt.cloudTrace, func(t *testing.T) {
+			t.Setenv("OTEL_PROPAGATORS", tt.propagators)
+			s, exp := newTestServer(t, testConfig(t))
+			logs := captureLogs(t)
+			r := httptest.NewRequest("GET", "/echo/hi", nil)
+			r.Header.Set("traceparent", "00-"+w3cTraceID+"-00f067aa0ba902b7-01")
+			r.Header.Set(cloudTraceContextHeader, tt.cloudTrace)
+
+			serve(s, r)
+
+			span := findSpan(t, exp, "echo-handler")
+			if got := span.SpanContext.TraceID().String(); got != w3cTraceID {
+				t.Errorf("trace ID = %s, want the traceparent trace %s", got, w3cTraceID)
+			}
+			if v, _ := spanAttr(span, "trace_header_conflict"); v.AsBool() != tt.wantConflict {
+				t.Errorf("trace_header_conflict = %t, want %t", v.AsBool(), tt.wantConflict)
+			}
+			logged := countLogs(logs(), "conflicting trace headers, continuing the traceparent trace") > 0
+			if logged != tt.wantConflict {
+				t.Errorf("logged the conflict = %t, want %t", logged, tt.wantConflict)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// negotiateContentType picks the first of offers, in order of preference, that
+// the Accept header allows with the highest quality. An empty header accepts
+// the first offer; ok is false when nothing is acceptable.
+func negotiateContentType(accept string, offers ...string) (contentType string, ok bool) {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	bestQ := 0.0
+	for _, offer := range offers {
+		q := acceptQuality(accept, offer)
+		if q > bestQ {
+			contentType, bestQ = offer, q
+		}
+	}
+	return contentType, bestQ > 0
+}
+
+// acceptQuality returns the quality the Accept header assigns to mediaType,
+// using the most specific matching range.
+func acceptQuality(accept, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		rng, params, _ := strings.Cut(part, ";")
+		rng = strings.ToLower(strings.TrimSpace(rng))
+
+		s := -1
+		switch {
+		case rng == mediaType:
+			s = 2
+		case rng == typ+"/*":
+			s = 1
+		case rng == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+	}
+	return q
+}
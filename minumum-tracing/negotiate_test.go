@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+		ok     bool
+	}{
+		{"", "application/json", true},
+		{"*/*", "application/json", true},
+		{"text/plain", "text/plain", true},
+		{"text/*", "text/plain", true},
+		{"text/plain;q=0.5, application/json", "application/json", true},
+		{"application/json;q=0.1, text/plain;q=0.9", "text/plain", true},
+		{"application/json;q=0, */*", "text/plain", true},
+		{"image/png", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := negotiateContentType(tt.accept, "application/json", "text/plain")
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("negotiateContentType(%q) = %q, %t, want %q, %t", tt.accept, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEchoContentNegotiation(t *testing.T) {
+	tests := []struct {
+		accept      string
+		wantStatus  int
+		contentType string
+	}{
+		{"application/json", http.StatusOK, "application/json"},
+		{"*/*", http.StatusOK, "application/json"},
+		{"text/plain", http.StatusOK, "text/plain"},
+		{"image/png", http.StatusNotAcceptable, ""},
+	}
+	for _, tt := range tests {
+		s, exp := newTestServer(t, testConfig(t))
+		r := httptest.NewRequest("GET", "/echo/hi", nil)
+		r.Header.Set("Accept", tt.accept)
+
+		w := serve(s, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("Accept %s: status = %d, want %d", tt.accept, w.Code, tt.wantStatus)
+			continue
+		}
+		if tt.contentType == "" {
+			continue
+		}
+		v, _ := spanAttr(findSpan(t, exp, "echo-handler"), "http.response.content_type")
+		if v.AsString() != tt.contentType {
+			t.Errorf("Accept %s: span content type = %q, want %q", tt.accept, v.AsString(), tt.contentType)
+		}
+		if tt.contentType == "text/plain" && w.Body.String() != "hi" {
+			t.Errorf("Accept %s: body = %q, want hi", tt.accept, w.Body.String())
+		}
+	}
+}
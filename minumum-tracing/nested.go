@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errControlCharacter = errors.New("message must not contain control characters")
+
+// NestedResponse is the response of GET /echo/{message}/nested.
+type NestedResponse struct {
+	Message     string `json:"message"`
+	Transformed string `json:"transformed"`
+}
+
+// handleEchoNested echoes the message after passing it through validate and
+// transform, each of which records its own child span of the handler span.
+// It shows how passing ctx down the call chain nests the spans.
+func (s *server) handleEchoNested(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	message := r.PathValue("message")
+	if !s.checkMessageLength(w, r, message) {
+		return
+	}
+	span.SetAttributes(s.messageAttributes(message)...)
+
+	if err := s.validate(ctx, message); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		writeJSONError(w, http.StatusBadRequest, err.Error(), traceIDFromContext(ctx))
+		return
+	}
+	transformed := s.transform(ctx, message)
+	span.SetAttributes(attribute.Int("nested.steps", 2))
+
+	s.echoCount.Add(ctx, 1)
+	s.writeJSON(ctx, w, s.withResponseField(NestedResponse{Message: message, Transformed: transformed}))
+}
+
+// validate rejects messages containing control characters.
+func (s *server) validate(ctx context.Context, message string) error {
+	_, span := s.tracer.Start(ctx, "validate")
+	defer span.End()
+
+	valid := !strings.ContainsFunc(message, unicode.IsControl)
+	span.SetAttributes(
+		attribute.Int("validate.length", len(message)),
+		attribute.Bool("validate.valid", valid),
+	)
+	if !valid {
+		span.RecordError(errControlCharacter)
+		span.SetStatus(codes.Error, errControlCharacter.Error())
+		return errControlCharacter
+	}
+	return nil
+}
+
+// transform returns the message reversed, character by character.
+func (s *server) transform(ctx context.Context, message string) string {
+	_, span := s.tracer.Start(ctx, "transform")
+	defer span.End()
+
+	runes := []rune(message)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	span.SetAttributes(
+		attribute.String("transform.operation", "reverse"),
+		attribute.Int("transform.characters", len(runes)),
+	)
+	return string(runes)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestEchoNestedSpans(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("GET", "/echo/abc/nested", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp NestedResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp != (NestedResponse{Message: "abc", Transformed: "cba"}) {
+		t.Errorf("response = %+v (%v), want abc transformed to cba", resp, err)
+	}
+	if n := len(exp.GetSpans()); n != 3 {
+		t.Errorf("recorded %d spans, want the handler span and two children", n)
+	}
+	handler := findSpan(t, exp, "echo-nested-handler")
+	for _, name := range []string{"validate", "transform"} {
+		child := findSpan(t, exp, name)
+		if child.Parent.SpanID() != handler.SpanContext.SpanID() {
+			t.Errorf("%s parent = %s, want the handler span %s", name, child.Parent.SpanID(), handler.SpanContext.SpanID())
+		}
+	}
+	if v, _ := spanAttr(handler, "nested.steps"); v.AsInt64() != 2 {
+		t.Errorf("nested.steps = %d, want 2", v.AsInt64())
+	}
+	if v, _ := spanAttr(findSpan(t, exp, "validate"), "validate.valid"); !v.AsBool() {
+		t.Error("validate.valid = false, want true")
+	}
+	if v, _ := spanAttr(findSpan(t, exp, "transform"), "transform.operation"); v.AsString() != "reverse" {
+		t.Errorf("transform.operation = %q, want reverse", v.AsString())
+	}
+}
+
+func TestEchoNestedInvalid(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("GET", "/echo/a%07b/nested", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if span := findSpan(t, exp, "validate"); span.Status.Code != codes.Error {
+		t.Errorf("validate status = %v, want error", span.Status.Code)
+	}
+	for _, span := range exp.GetSpans() {
+		if span.Name == "transform" {
+			t.Error("transformed a message that failed validation")
+		}
+	}
+}
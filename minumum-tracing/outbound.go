@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	outboundTimeout = 10 * time.Second
+	// maxOutboundBodyBytes caps how much of the upstream body is relayed.
+	maxOutboundBodyBytes = 64 << 10
+)
+
+// OutboundResponse is the response of GET /outbound and GET
+// /outbound/priority.
+type OutboundResponse struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// newOutboundClient returns a client whose requests create client spans and
+// carry the active trace context to the upstream server.
+func newOutboundClient() *http.Client {
+	return &http.Client{
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+		Timeout:   outboundTimeout,
+	}
+}
+
+// handleOutbound calls OUTBOUND_URL within the request's trace and relays
+// the upstream status and body.
+func (s *server) handleOutbound(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.outboundURL, nil)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid outbound request")
+		writeJSONError(w, http.StatusInternalServerError, "invalid outbound request", traceIDFromContext(ctx))
+		return
+	}
+	resp, err := s.outboundClient.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "outbound request failed")
+		slog.ErrorContext(ctx, "outbound request failed", "url", s.outboundURL, "error", err)
+		writeJSONError(w, http.StatusBadGateway, "outbound request failed", traceIDFromContext(ctx))
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOutboundBodyBytes))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "read outbound response")
+		writeJSONError(w, http.StatusBadGateway, "failed to read outbound response", traceIDFromContext(ctx))
+		return
+	}
+	span.SetAttributes(attribute.Int("outbound.status_code", resp.StatusCode))
+
+	s.writeJSON(ctx, w, OutboundResponse{URL: s.outboundURL, Status: resp.StatusCode, Body: string(body)})
+}
+
+// outboundPriorities are the accepted values of the priority query parameter
+// of GET /outbound/priority.
+var outboundPriorities = []string{"low", "normal", "high"}
+
+// handleOutboundPriority makes the same call as handleOutbound with the
+// request.priority baggage member set from the priority query parameter,
+// normal by default, so that the upstream receives it in the baggage header.
+func (s *server) handleOutboundPriority(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	priority := r.URL.Query().Get("priority")
+	if priority == "" {
+		priority = "normal"
+	}
+	if !slices.Contains(outboundPriorities, priority) {
+		writeJSONError(w, http.StatusBadRequest, "priority must be one of low, normal or high", traceIDFromContext(ctx))
+		return
+	}
+
+	bag, err := withBaggageMember(baggage.FromContext(ctx), "request.priority", priority)
+	if err != nil {
+		span.RecordError(err)
+		writeJSONError(w, http.StatusInternalServerError, "failed to set baggage", traceIDFromContext(ctx))
+		return
+	}
+	ctx = baggage.ContextWithBaggage(ctx, bag)
+	span.SetAttributes(
+		attribute.String("baggage.request.priority", priority),
+		// Baggage only reaches the upstream if OTEL_PROPAGATORS includes it.
+		attribute.Bool("baggage.propagated", slices.Contains(otel.GetTextMapPropagator().Fields(), "baggage")),
+	)
+
+	s.handleOutbound(w, r.WithContext(ctx))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestOutboundPropagatesTrace(t *testing.T) {
+	var traceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Write([]byte("pong"))
+	}))
+	defer upstream.Close()
+	t.Setenv("OUTBOUND_URL", upstream.URL)
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("GET", "/outbound", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp OutboundResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Body != "pong" {
+		t.Errorf("response = %+v (%v), want the upstream body", resp, err)
+	}
+	server := findSpan(t, exp, "outbound-handler")
+	if !strings.Contains(traceparent, server.SpanContext.TraceID().String()) {
+		t.Errorf("upstream traceparent = %q, want trace %s", traceparent, server.SpanContext.TraceID())
+	}
+	var client bool
+	for _, span := range exp.GetSpans() {
+		if span.SpanKind == trace.SpanKindClient && span.Parent.SpanID() == server.SpanContext.SpanID() {
+			client = true
+			if !strings.Contains(traceparent, span.SpanContext.SpanID().String()) {
+				t.Errorf("upstream traceparent = %q, want the client span %s as parent", traceparent, span.SpanContext.SpanID())
+			}
+		}
+	}
+	if !client {
+		t.Error("no client span under the outbound-handler span")
+	}
+}
+
+func TestOutboundPriorityBaggage(t *testing.T) {
+	var bag string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bag = r.Header.Get("baggage")
+	}))
+	defer upstream.Close()
+	t.Setenv("OUTBOUND_URL", upstream.URL)
+	s, exp := newTestServer(t, testConfig(t))
+
+	r := httptest.NewRequest("GET", "/outbound/priority?priority=high", nil)
+	r.Header.Set("baggage", "session.id=abc")
+	w := serve(s, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if !strings.Contains(bag, "request.priority=high") || !strings.Contains(bag, "session.id=abc") {
+		t.Errorf("upstream baggage = %q, want request.priority=high alongside the incoming members", bag)
+	}
+	span := findSpan(t, exp, "outbound-priority-handler")
+	if v, _ := spanAttr(span, "baggage.request.priority"); v.AsString() != "high" {
+		t.Errorf("baggage.request.priority = %q, want high", v.AsString())
+	}
+	if v, _ := spanAttr(span, "baggage.propagated"); !v.AsBool() {
+		t.Error("baggage.propagated = false, want true with the default propagators")
+	}
+
+	if w := serve(s, httptest.NewRequest("GET", "/outbound/priority?priority=urgent", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown priority: status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// pprofRoutes registers the net/http/pprof handlers. They are plain routes,
+// not traced ones, so that profiling does not add noise to traces, but they
+// are audited.
+func (s *server) pprofRoutes() {
+	s.handle("GET /debug/pprof/", audit(http.HandlerFunc(pprof.Index)))
+	s.handle("GET /debug/pprof/cmdline", audit(http.HandlerFunc(pprof.Cmdline)))
+	s.handle("GET /debug/pprof/profile", audit(http.HandlerFunc(pprof.Profile)))
+	s.handle("GET /debug/pprof/symbol", audit(http.HandlerFunc(pprof.Symbol)))
+	s.handle("POST /debug/pprof/symbol", audit(http.HandlerFunc(pprof.Symbol)))
+	s.handle("GET /debug/pprof/trace", audit(http.HandlerFunc(pprof.Trace)))
+}
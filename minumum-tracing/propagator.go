@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/contrib/propagators/b3"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultPropagators is used when OTEL_PROPAGATORS is unset.
+const defaultPropagators = "gcp,tracecontext,baggage"
+
+// parsePropagators builds the composite propagator named by a
+// comma-separated OTEL_PROPAGATORS list. Propagators extract in list order,
+// so a later one overrides the trace context found by an earlier one. The
+// exception is gcp, which is always moved before tracecontext so that a
+// traceparent header wins over a conflicting X-Cloud-Trace-Context whatever
+// the list order. Adding b3 or b3multi continues traces from callers that
+// only send B3 headers. It also returns the names in use, in extraction
+// order.
+func parsePropagators(list string) (propagation.TextMapPropagator, []string, error) {
+	var (
+		props []propagation.TextMapPropagator
+		names []string
+		// Indexes of the tracecontext and gcp propagators in props.
+		w3c, gcp = -1, -1
+	)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		var p propagation.TextMapPropagator
+		switch name {
+		case "":
+			continue
+		case "none":
+			return propagation.NewCompositeTextMapPropagator(), nil, nil
+		case "tracecontext":
+			p = propagation.TraceContext{}
+			w3c = len(props)
+		case "baggage":
+			p = propagation.Baggage{}
+		case "b3":
+			// Extraction accepts both B3 encodings; this only picks the one
+			// injected into outgoing requests.
+			p = b3Sampling{b3.New(b3.WithInjectEncoding(b3.B3SingleHeader))}
+		case "b3multi":
+			p = b3Sampling{b3.New(b3.WithInjectEncoding(b3.B3MultipleHeader))}
+		case "gcp", "cloudtrace":
+			p = cloudTraceContext{}
+			gcp = len(props)
+		default:
+			return nil, nil, fmt.Errorf("unsupported propagator %q in OTEL_PROPAGATORS", name)
+		}
+		props = append(props, p)
+		names = append(names, name)
+	}
+	if w3c >= 0 && gcp > w3c {
+		p, name := props[gcp], names[gcp]
+		props = slices.Insert(slices.Delete(props, gcp, gcp+1), w3c, p)
+		names = slices.Insert(slices.Delete(names, gcp, gcp+1), w3c, name)
+	}
+	return propagation.NewCompositeTextMapPropagator(props...), names, nil
+}
+
+// b3Sampling honours the sampling decision of a B3 caller regardless of the
+// configured sampler: a request the caller sampled, or flagged for debugging,
+// is sampled here too, even when it carries no trace IDs to continue.
+type b3Sampling struct {
+	propagation.TextMapPropagator
+}
+
+func (p b3Sampling) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	ctx = p.TextMapPropagator.Extract(ctx, carrier)
+	if b3Sampled(carrier) {
+		ctx = withForceTrace(ctx)
+	}
+	return ctx
+}
+
+// b3Sampled reports whether the B3 headers in carrier ask for sampling,
+// either in the single b3 header, TRACEID-SPANID-SAMPLED or just SAMPLED, or
+// in X-B3-Sampled and X-B3-Flags.
+func b3Sampled(carrier propagation.TextMapCarrier) bool {
+	if v := carrier.Get("b3"); v != "" {
+		parts := strings.Split(v, "-")
+		if len(parts) == 1 {
+			return v == "1" || v == "d"
+		}
+		return len(parts) >= 3 && (parts[2] == "1" || parts[2] == "d")
+	}
+	if carrier.Get("x-b3-flags") == "1" {
+		return true
+	}
+	sampled := carrier.Get("x-b3-sampled")
+	return sampled == "1" || sampled == "true"
+}
+
+const cloudTraceContextHeader = "X-Cloud-Trace-Context"
+
+// cloudTraceContext propagates trace context in the X-Cloud-Trace-Context
+// format used by Google Cloud load balancers: TRACE_ID/SPAN_ID;o=OPTIONS,
+// where SPAN_ID is a decimal uint64.
+type cloudTraceContext struct{}
+
+var _ propagation.TextMapPropagator = cloudTraceContext{}
+
+func (cloudTraceContext) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	spanID := sc.SpanID()
+	sampled := 0
+	if sc.IsSampled() {
+		sampled = 1
+	}
+	carrier.Set(cloudTraceContextHeader, fmt.Sprintf("%s/%d;o=%d",
+		sc.TraceID(), binary.BigEndian.Uint64(spanID[:]), sampled))
+}
+
+func (cloudTraceContext) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	sc, err := parseCloudTraceContext(carrier.Get(cloudTraceContextHeader))
+	if err != nil {
+		return ctx
+	}
+	return trace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+func (cloudTraceContext) Fields() []string {
+	return []string{cloudTraceContextHeader}
+}
+
+func parseCloudTraceContext(h string) (trace.SpanContext, error) {
+	if h == "" {
+		return trace.SpanContext{}, fmt.Errorf("empty %s header", cloudTraceContextHeader)
+	}
+
+	h, options, _ := strings.Cut(h, ";")
+	traceIDStr, spanIDStr, ok := strings.Cut(h, "/")
+	if !ok {
+		return trace.SpanContext{}, fmt.Errorf("missing span ID in %q", h)
+	}
+
+	traceID, err := trace.TraceIDFromHex(traceIDStr)
+	if err != nil {
+		return trace.SpanContext{}, fmt.Errorf("invalid trace ID: %w", err)
+	}
+	n, err := strconv.ParseUint(spanIDStr, 10, 64)
+	if err != nil || n == 0 {
+		return trace.SpanContext{}, fmt.Errorf("invalid span ID %q", spanIDStr)
+	}
+	var spanID trace.SpanID
+	binary.BigEndian.PutUint64(spanID[:], n)
+
+	var flags trace.TraceFlags
+	if options == "o=1" {
+		flags = trace.FlagsSampled
+	}
+
+	return trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: flags,
+		Remote:     true,
+	}), nil
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestParseCloudTraceContext(t *testing.T) {
+	tests := []struct {
+		header  string
+		traceID string
+		spanID  string
+		sampled bool
+		wantErr bool
+	}{
+		{
+			header:  "105445aa7843bc8bf206b12000100000/9003635583235412630;o=1",
+			traceID: "105445aa7843bc8bf206b12000100000",
+			spanID:  "7cf356dc11d05296",
+			sampled: true,
+		},
+		{
+			header:  "105445aa7843bc8bf206b12000100000/1;o=0",
+			traceID: "105445aa7843bc8bf206b12000100000",
+			spanID:  "0000000000000001",
+		},
+		{
+			header:  "105445aa7843bc8bf206b12000100000/1",
+			traceID: "105445aa7843bc8bf206b12000100000",
+			spanID:  "0000000000000001",
+		},
+		{header: "", wantErr: true},
+		{header: "105445aa7843bc8bf206b12000100000", wantErr: true},
+		{header: "not-a-trace-id/1;o=1", wantErr: true},
+		{header: "105445aa7843bc8bf206b12000100000/0;o=1", wantErr: true},
+		{header: "105445aa7843bc8bf206b12000100000/abc;o=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		sc, err := parseCloudTraceContext(tt.header)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCloudTraceContext(%q) = %v, want an error", tt.header, sc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCloudTraceContext(%q) error = %v", tt.header, err)
+			continue
+		}
+		if got := sc.TraceID().String(); got != tt.traceID {
+			t.Errorf("parseCloudTraceContext(%q) trace ID = %s, want %s", tt.header, got, tt.traceID)
+		}
+		if got := sc.SpanID().String(); got != tt.spanID {
+			t.Errorf("parseCloudTraceContext(%q) span ID = %s, want %s", tt.header, got, tt.spanID)
+		}
+		if sc.IsSampled() != tt.sampled || !sc.IsRemote() {
+			t.Errorf("parseCloudTraceContext(%q) = %v, want sampled %t and remote", tt.header, sc, tt.sampled)
+		}
+	}
+}
+
+func TestCloudTraceContextRoundTrip(t *testing.T) {
+	want, err := parseCloudTraceContext("105445aa7843bc8bf206b12000100000/9003635583235412630;o=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	carrier := propagation.MapCarrier{}
+	cloudTraceContext{}.Inject(trace.ContextWithSpanContext(context.Background(), want), carrier)
+	got := trace.SpanContextFromContext(cloudTraceContext{}.Extract(context.Background(), carrier))
+	if !got.Equal(want) {
+		t.Errorf("round trip = %v, want %v", got, want)
+	}
+}
+
+func TestParsePropagators(t *testing.T) {
+	tests := []struct {
+		list      string
+		wantNames []string
+		wantErr   bool
+	}{
+		{defaultPropagators, []string{"gcp", "tracecontext", "baggage"}, false},
+		{"tracecontext, baggage, b3, gcp", []string{"gcp", "tracecontext", "baggage", "b3"}, false},
+		{"B3MULTI,,baggage", []string{"b3multi", "baggage"}, false},
+		{"cloudtrace", []string{"cloudtrace"}, false},
+		{"none", nil, false},
+		{"tracecontext,jaeger", nil, true},
+	}
+	for _, tt := range tests {
+		p, names, err := parsePropagators(tt.list)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parsePropagators(%q) succeeded, want an error", tt.list)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePropagators(%q) error = %v", tt.list, err)
+			continue
+		}
+		if !slices.Equal(names, tt.wantNames) {
+			t.Errorf("parsePropagators(%q) names = %q, want %q", tt.list, names, tt.wantNames)
+		}
+		if p == nil {
+			t.Errorf("parsePropagators(%q) returned no propagator", tt.list)
+		}
+	}
+}
+
+func TestParsePropagatorsFields(t *testing.T) {
+	p, _, err := parsePropagators("tracecontext,baggage,gcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := p.Fields()
+	for _, want := range []string{"traceparent", "baggage", cloudTraceContextHeader} {
+		if !slices.Contains(fields, want) {
+			t.Errorf("fields = %q, want %s", fields, want)
+		}
+	}
+}
+
+func TestB3Propagation(t *testing.T) {
+	const traceID = "80f198ee56343ba864fe8b2a57d3eff7"
+	tests := []struct {
+		name    string
+		headers map[string]string
+	}{
+		{"single header", map[string]string{"b3": traceID + "-e457b5a2e4d86bd1-1"}},
+		{"multiple headers", map[string]string{
+			"X-B3-TraceId": traceID,
+			"X-B3-SpanId":  "e457b5a2e4d86bd1",
+			"X-B3-Sampled": "1",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OTEL_PROPAGATORS", "tracecontext,baggage,b3")
+			s, exp := newTestServer(t, testConfig(t))
+			r := httptest.NewRequest("GET", "/echo/hi", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			serve(s, r)
+
+			span := findSpan(t, exp, "echo-handler")
+			if got := span.SpanContext.TraceID().String(); got != traceID {
+				t.Errorf("trace ID = %s, want %s", got, traceID)
+			}
+			if got := span.Parent.SpanID().String(); got != "e457b5a2e4d86bd1" {
+				t.Errorf("parent span ID = %s, want e457b5a2e4d86bd1", got)
+			}
+		})
+	}
+}
+
+func TestB3Sampled(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    bool
+	}{
+		{map[string]string{"b3": "1"}, true},
+		{map[string]string{"b3": "d"}, true},
+		{map[string]string{"b3": "0"}, false},
+		{map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-0"}, false},
+		{map[string]string{"x-b3-sampled": "true"}, true},
+		{map[string]string{"x-b3-flags": "1"}, true},
+		{map[string]string{}, false},
+	}
+	for _, tt := range tests {
+		if got := b3Sampled(propagation.MapCarrier(tt.headers)); got != tt.want {
+			t.Errorf("b3Sampled(%v) = %t, want %t", tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestB3SampledOverridesZeroRatio(t *testing.T) {
+	const traceID = "80f198ee56343ba864fe8b2a57d3eff7"
+	t.Setenv("OTEL_PROPAGATORS", "tracecontext,baggage,b3")
+	t.Setenv("OTEL_TRACES_SAMPLER", "traceidratio")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+	if n := len(exp.GetSpans()); n != 0 {
+		t.Fatalf("recorded %d spans without B3 headers, want none with a zero ratio", n)
+	}
+
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("X-B3-TraceId", traceID)
+	r.Header.Set("X-B3-SpanId", "e457b5a2e4d86bd1")
+	r.Header.Set("X-B3-Sampled", "1")
+	serve(s, r)
+
+	span := findSpan(t, exp, "echo-handler")
+	if got := span.SpanContext.TraceID().String(); got != traceID || !span.SpanContext.IsSampled() {
+		t.Errorf("span in trace %s, sampled %t, want the upstream's sampled trace %s", got, span.SpanContext.IsSampled(), traceID)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+type clientIPKey struct{}
+
+// clientIP returns the client address resolved by resolveClientIP, or the
+// peer address for requests that did not go through it.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// resolveClientIP records the original client address of each request for
+// clientIP. App Engine sits behind a proxy, so the address is read from
+// X-Forwarded-For; but the client controls every entry except those the
+// proxies append, so only the entry added by the outermost of the hops
+// trusted proxies is used, counting from the right. A header with fewer
+// entries, or an invalid one, falls back to RemoteAddr, and so does every
+// request when hops is 0.
+func resolveClientIP(hops int) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip, ok := forwardedClientIP(r.Header.Values("X-Forwarded-For"), hops)
+			if !ok {
+				ip = remoteIP(r)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+		})
+	}
+}
+
+// forwardedClientIP returns the X-Forwarded-For entry hops positions from
+// the right, as long as it is a valid IP, optionally with a port. Repeated
+// headers are read as one list.
+func forwardedClientIP(headers []string, hops int) (string, bool) {
+	if hops <= 0 {
+		return "", false
+	}
+	var entries []string
+	for _, h := range headers {
+		entries = append(entries, strings.Split(h, ",")...)
+	}
+	if len(entries) < hops {
+		return "", false
+	}
+	return parseForwardedIP(entries[len(entries)-hops])
+}
+
+// originalClientIP returns the first X-Forwarded-For entry, the address of
+// the client that sent the request, or the peer address when there is no
+// valid one. The client can write anything there, so the address is only
+// recorded for debugging; rate limiting and the audit log use clientIP.
+func originalClientIP(r *http.Request) string {
+	h := r.Header.Get("X-Forwarded-For")
+	if h == "" {
+		return remoteIP(r)
+	}
+	first, _, _ := strings.Cut(h, ",")
+	if ip, ok := parseForwardedIP(first); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// parseForwardedIP parses an X-Forwarded-For entry as an IP, optionally with
+// a port.
+func parseForwardedIP(entry string) (string, bool) {
+	entry = strings.TrimSpace(entry)
+	if host, _, err := net.SplitHostPort(entry); err == nil {
+		entry = host
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// remoteIP returns the host part of the address of the peer connection.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimiterIdleTTL is how long a client limiter is kept without requests.
+const rateLimiterIdleTTL = 3 * time.Minute
+
+// rateLimiter hands out a token bucket per client IP.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(limit float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:   rate.Limit(limit),
+		burst:   burst,
+		clients: make(map[string]*clientLimiter),
+	}
+}
+
+func (rl *rateLimiter) get(ip string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > rateLimiterIdleTTL {
+		for k, c := range rl.clients {
+			if now.Sub(c.lastSeen) > rateLimiterIdleTTL {
+				delete(rl.clients, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	c, ok := rl.clients[ip]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.clients[ip] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+// middleware rejects clients exceeding their token bucket with 429. A nil
+// rateLimiter disables limiting.
+func (rl *rateLimiter) middleware(next http.Handler) http.Handler {
+	if rl == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		lim := rl.get(ip)
+		if lim.Allow() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res := lim.Reserve()
+		wait := res.Delay()
+		res.Cancel()
+		retryAfter := int(math.Max(1, math.Ceil(wait.Seconds())))
+
+		ctx := r.Context()
+		trace.SpanFromContext(ctx).AddEvent("rate_limited", trace.WithAttributes(
+			attribute.String("client.ip", ip),
+			attribute.Int("retry_after_seconds", retryAfter),
+		))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		writeJSONError(w, http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests), traceIDFromContext(ctx))
+	})
+}
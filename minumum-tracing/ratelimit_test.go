@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestForwardedClientIP(t *testing.T) {
+	tests := []struct {
+		headers []string
+		hops    int
+		want    string
+		ok      bool
+	}{
+		{[]string{"203.0.113.7"}, 1, "203.0.113.7", true},
+		{[]string{"198.51.100.1, 203.0.113.7"}, 1, "203.0.113.7", true},
+		{[]string{"198.51.100.1, 203.0.113.7, 10.0.0.1"}, 2, "203.0.113.7", true},
+		{[]string{"198.51.100.1", "203.0.113.7"}, 1, "203.0.113.7", true},
+		{[]string{"[2001:db8::1]:443"}, 1, "2001:db8::1", true},
+		{[]string{"203.0.113.7:8080"}, 1, "203.0.113.7", true},
+		{[]string{"203.0.113.7"}, 2, "", false},
+		{[]string{"203.0.113.7"}, 0, "", false},
+		{[]string{"unknown"}, 1, "", false},
+		{nil, 1, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := forwardedClientIP(tt.headers, tt.hops)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("forwardedClientIP(%q, %d) = %q, %t, want %q, %t", tt.headers, tt.hops, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestOriginalClientIP(t *testing.T) {
+	tests := []struct {
+		headers []string
+		want    string
+	}{
+		{[]string{"203.0.113.7"}, "203.0.113.7"},
+		{[]string{"198.51.100.1, 203.0.113.7, 169.254.1.1"}, "198.51.100.1"},
+		{[]string{"198.51.100.1", "203.0.113.7"}, "198.51.100.1"},
+		{[]string{" [2001:db8::1]:443 , 203.0.113.7"}, "2001:db8::1"},
+		{[]string{"unknown, 203.0.113.7"}, "192.0.2.1"},
+		{nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		for _, h := range tt.headers {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := originalClientIP(r); got != tt.want {
+			t.Errorf("originalClientIP(%q) = %q, want %q", tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT", "1")
+	t.Setenv("RATE_BURST", "2")
+	s, exp := newTestServer(t, testConfig(t))
+
+	var codes []int
+	for i := range 4 {
+		r := httptest.NewRequest("GET", "/echo/hi", nil)
+		// Changing the leftmost entry, which the client controls, must not
+		// escape the limit.
+		r.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i+1)+", 203.0.113.7")
+		w := serve(s, r)
+		codes = append(codes, w.Code)
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Error("429 response without Retry-After")
+		}
+	}
+
+	want := []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}
+	for i := range want {
+		if codes[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", codes, want)
+		}
+	}
+	var limited int
+	for _, span := range exp.GetSpans() {
+		for _, e := range span.Events {
+			if e.Name == "rate_limited" {
+				limited++
+			}
+		}
+	}
+	if limited != 2 {
+		t.Errorf("recorded %d rate_limited events, want 2", limited)
+	}
+
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.8")
+	if w := serve(s, r); w.Code != http.StatusOK {
+		t.Errorf("another client: status = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handleEchoRaw returns the request body verbatim with the same Content-Type,
+// for checking what a client actually sends: no charset is added to it. The
+// body size is capped by the bodyLimit middleware.
+func (s *server) handleEchoRaw(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		span.RecordError(err)
+		writeDecodeError(w, r, err)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	span.SetAttributes(
+		attribute.String("echo.raw.content_type", contentType),
+		attribute.Int("echo.raw.size", len(body)),
+	)
+	slog.InfoContext(ctx, "received raw echo request", "content_type", contentType, "size", len(body))
+
+	keepContentType(w)
+	w.Header().Set("Content-Type", contentType)
+	// The body is arbitrary client input, so browsers must neither guess
+	// another type nor run it as an active document.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	if _, err := w.Write(body); err != nil {
+		span.RecordError(err)
+	}
+}
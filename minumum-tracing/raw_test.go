@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEchoRaw(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		wantType    string
+	}{
+		{"json", "application/json; charset=utf-8", []byte(`{"message":"hi"}`), "application/json; charset=utf-8"},
+		{"text", "text/plain; charset=utf-8", []byte("hello\nworld"), "text/plain; charset=utf-8"},
+		{"bare json", "application/json", []byte(`{"message":"hi"}`), "application/json"},
+		{"bare text", "text/plain", []byte("hello"), "text/plain"},
+		{"latin-1 text", "text/plain; charset=iso-8859-1", []byte("caf\xe9"), "text/plain; charset=iso-8859-1"},
+		{"binary", "image/png", []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}, "image/png"},
+		{"no content type", "", []byte{0x00, 0x01}, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, exp := newTestServer(t, testConfig(t))
+			r := httptest.NewRequest("POST", "/echo/raw", bytes.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+
+			w := serve(s, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Errorf("body = %q, want %q", w.Body.Bytes(), tt.body)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			span := findSpan(t, exp, "echo-raw-handler")
+			if v, _ := spanAttr(span, "echo.raw.content_type"); v.AsString() != tt.wantType {
+				t.Errorf("echo.raw.content_type = %q, want %q", v.AsString(), tt.wantType)
+			}
+			if v, _ := spanAttr(span, "echo.raw.size"); v.AsInt64() != int64(len(tt.body)) {
+				t.Errorf("echo.raw.size = %d, want %d", v.AsInt64(), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestEchoRawTooLarge(t *testing.T) {
+	t.Setenv("MAX_BODY_BYTES", "8")
+	s, _ := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("POST", "/echo/raw", bytes.NewReader(make([]byte, 9))))
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxRecordedRequests is how many requests /debug/requests keeps, the
+	// oldest being evicted first.
+	maxRecordedRequests = 100
+	// maxRecordedBodyBytes caps how much of each request body is kept.
+	maxRecordedBodyBytes = 4 << 10
+)
+
+// redactedHeaders are recorded without their values.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", adminTokenHeader}
+
+// RecordedRequest is a request captured for /debug/requests.
+type RecordedRequest struct {
+	Time          time.Time   `json:"time"`
+	Method        string      `json:"method"`
+	Path          string      `json:"path"`
+	Query         string      `json:"query,omitempty"`
+	Header        http.Header `json:"header"`
+	Body          string      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+}
+
+// requestRecorder keeps the most recent requests in a ring buffer.
+type requestRecorder struct {
+	mu       sync.Mutex
+	requests []RecordedRequest
+	next     int
+}
+
+func newRequestRecorder() *requestRecorder {
+	return &requestRecorder{requests: make([]RecordedRequest, 0, maxRecordedRequests)}
+}
+
+// middleware records every request before passing it on with its body
+// intact. A nil requestRecorder records nothing.
+func (rr *requestRecorder) middleware(next http.Handler) http.Handler {
+	if rr == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := RecordedRequest{
+			Time:   time.Now(),
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  r.URL.RawQuery,
+			Header: r.Header.Clone(),
+		}
+		for _, h := range redactedHeaders {
+			if rec.Header.Get(h) != "" {
+				rec.Header.Set(h, "[redacted]")
+			}
+		}
+		if r.Body != nil && r.Body != http.NoBody {
+			// Read one byte more than kept to tell whether the body was cut.
+			prefix, err := io.ReadAll(io.LimitReader(r.Body, maxRecordedBodyBytes+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(prefix), r.Body), r.Body}
+			if err == nil {
+				rec.BodyTruncated = len(prefix) > maxRecordedBodyBytes
+				rec.Body = string(prefix[:min(len(prefix), maxRecordedBodyBytes)])
+			}
+		}
+		rr.add(rec)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rr *requestRecorder) add(rec RecordedRequest) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	if len(rr.requests) < maxRecordedRequests {
+		rr.requests = append(rr.requests, rec)
+		return
+	}
+	rr.requests[rr.next] = rec
+	rr.next = (rr.next + 1) % maxRecordedRequests
+}
+
+// recorded returns the recorded requests, oldest first.
+func (rr *requestRecorder) recorded() []RecordedRequest {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	out := make([]RecordedRequest, 0, len(rr.requests))
+	out = append(out, rr.requests[rr.next:]...)
+	return append(out, rr.requests[:rr.next]...)
+}
+
+// readCloser reads from Reader and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// handleDebugRequests lists the recorded requests. It is only registered when
+// RECORD_REQUESTS is enabled.
+func (s *server) handleDebugRequests(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(r.Context(), w, s.requestRecorder.recorded())
+}
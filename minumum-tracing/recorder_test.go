@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugRequests(t *testing.T) {
+	t.Setenv("RECORD_REQUESTS", "true")
+	s, _ := newTestServer(t, testConfig(t))
+	r := httptest.NewRequest("POST", "/echo?lang=en", strings.NewReader(`{"message":"hi"}`))
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Custom", "kept")
+
+	// The handler still reads the body the recorder already looked at.
+	if w := serve(s, r); w.Code != http.StatusOK {
+		t.Fatalf("POST /echo status = %d, want %d", w.Code, http.StatusOK)
+	}
+	w := serve(s, httptest.NewRequest("GET", "/debug/requests", nil))
+
+	var recorded []RecordedRequest
+	if err := json.NewDecoder(w.Body).Decode(&recorded); err != nil {
+		t.Fatalf("decode requests: %v", err)
+	}
+	if len(recorded) == 0 {
+		t.Fatal("no request recorded")
+	}
+	got := recorded[0]
+	if got.Method != "POST" || got.Path != "/echo" || got.Query != "lang=en" || got.Body != `{"message":"hi"}` {
+		t.Errorf("recorded %s %s?%s %q, want POST /echo?lang=en with its body", got.Method, got.Path, got.Query, got.Body)
+	}
+	if got.Header.Get("Authorization") != "[redacted]" || got.Header.Get("X-Custom") != "kept" {
+		t.Errorf("recorded headers = %v, want Authorization redacted and the others kept", got.Header)
+	}
+}
+
+func TestRequestRecorderLimits(t *testing.T) {
+	rr := newRequestRecorder()
+	h := rr.middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	serve(h, httptest.NewRequest("POST", "/big", strings.NewReader(strings.Repeat("x", maxRecordedBodyBytes+1))))
+	for i := range maxRecordedRequests {
+		serve(h, httptest.NewRequest("GET", fmt.Sprintf("/%d", i), nil))
+	}
+
+	recorded := rr.recorded()
+	if len(recorded) != maxRecordedRequests || recorded[0].Path != "/0" || recorded[len(recorded)-1].Path != fmt.Sprintf("/%d", maxRecordedRequests-1) {
+		t.Errorf("recorded %d requests from %s, want the %d most recent, oldest first", len(recorded), recorded[0].Path, maxRecordedRequests)
+	}
+
+	rr = newRequestRecorder()
+	serve(rr.middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})),
+		httptest.NewRequest("POST", "/big", strings.NewReader(strings.Repeat("x", maxRecordedBodyBytes+1))))
+	if got := rr.recorded()[0]; len(got.Body) != maxRecordedBodyBytes || !got.BodyTruncated {
+		t.Errorf("recorded body of %d bytes, truncated %t, want %d bytes and truncated", len(got.Body), got.BodyTruncated, maxRecordedBodyBytes)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+)
+
+// reloadConfig re-reads the environment, as on SIGHUP, and applies the
+// settings that are safe to change on a running server: LOG_LEVEL,
+// OTEL_TRACES_SAMPLER_ARG and SLOW_THRESHOLD. The others need a restart. An
+// invalid configuration is logged and leaves every setting unchanged.
+func (s *server) reloadConfig(sampler *reloadableSampler) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		slog.Error("configuration reload failed, keeping the current settings", "error", err)
+		return
+	}
+
+	var changes []any
+	if ratio := sampler.ratio(); cfg.SamplerRatio != ratio {
+		if err := sampler.setRatio(cfg.SamplerRatio); err != nil {
+			slog.Error("configuration reload failed, keeping the current settings", "error", err)
+			return
+		}
+		changes = append(changes, slog.Group("sampler_ratio", "from", ratio, "to", cfg.SamplerRatio))
+	}
+	if level := s.logLevel.Level(); cfg.LogLevel != level {
+		s.logLevel.Set(cfg.LogLevel)
+		changes = append(changes, slog.Group("log_level", "from", level.String(), "to", cfg.LogLevel.String()))
+	}
+	if threshold := time.Duration(s.slowThreshold.Load()); cfg.SlowThreshold != threshold {
+		s.slowThreshold.Store(int64(cfg.SlowThreshold))
+		changes = append(changes, slog.Group("slow_threshold", "from", threshold.String(), "to", cfg.SlowThreshold.String()))
+	}
+
+	if len(changes) == 0 {
+		slog.Info("configuration reloaded, nothing changed")
+		return
+	}
+	slog.Info("configuration reloaded", changes...)
+}
+
+// reloadOnSignal reloads the configuration on every signal received on sigs,
+// until sigs is closed.
+func (s *server) reloadOnSignal(sigs <-chan os.Signal, sampler *reloadableSampler) {
+	for range sigs {
+		slog.Info("received SIGHUP, reloading configuration")
+		s.reloadConfig(sampler)
+	}
+}
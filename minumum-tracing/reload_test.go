@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	cfg := testConfig(t)
+	s, _ := newTestServer(t, cfg)
+	sampler, err := newReloadableSampler(cfg.Sampler, cfg.SamplerRatio)
+	if err != nil {
+		t.Fatalf("newReloadableSampler() error = %v", err)
+	}
+	logs := captureLogs(t)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.reloadOnSignal(sigs, sampler)
+	}()
+	t.Cleanup(func() {
+		signal.Stop(sigs)
+		close(sigs)
+		<-done
+	})
+
+	t.Setenv("LOG_LEVEL", "debug")
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("send SIGHUP: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for countLogs(logs(), "configuration reloaded") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("configuration not reloaded after SIGHUP")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := s.logLevel.Level(); got != slog.LevelDebug {
+		t.Errorf("log level = %s after SIGHUP, want %s", got, slog.LevelDebug)
+	}
+	entry := findLog(t, logs(), "configuration reloaded")
+	change, _ := entry["log_level"].(map[string]any)
+	if change["from"] != "INFO" || change["to"] != "DEBUG" {
+		t.Errorf("log_level = %v, want the change from INFO to DEBUG", entry["log_level"])
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/contrib/detectors/gcp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+const defaultServiceName = "minimum-tracing"
+
+// newResource describes this service instance for exported telemetry.
+// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the App
+// Engine defaults.
+func newResource(ctx context.Context, instanceID string) (*resource.Resource, error) {
+	return resource.New(ctx,
+		resource.WithDetectors(gcp.NewDetector()),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(serviceName()),
+			semconv.ServiceVersion(serviceVersion()),
+			attribute.String("instance.id", instanceID),
+		),
+		resource.WithFromEnv(),
+	)
+}
+
+// newInstanceID identifies the instance serving requests, so that spans and
+// logs can be told apart when several run at once. Outside App Engine, a
+// random ID is generated for the lifetime of the process.
+func newInstanceID() string {
+	if id := os.Getenv("GAE_INSTANCE"); id != "" {
+		return id
+	}
+	return uuid.NewString()
+}
+
+func serviceName() string {
+	if name := os.Getenv("GAE_SERVICE"); name != "" {
+		return name
+	}
+	return defaultServiceName
+}
+
+func serviceVersion() string {
+	if version == "dev" {
+		if v := os.Getenv("GAE_VERSION"); v != "" {
+			return v
+		}
+	}
+	return version
+}
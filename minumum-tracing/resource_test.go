@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+func TestNewResource(t *testing.T) {
+	tests := []struct {
+		name       string
+		gaeService string
+		otelName   string
+		want       string
+	}{
+		{name: "default", want: defaultServiceName},
+		{name: "app engine", gaeService: "echo", want: "echo"},
+		{name: "env override", gaeService: "echo", otelName: "custom", want: "custom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GAE_SERVICE", tt.gaeService)
+			t.Setenv("OTEL_SERVICE_NAME", tt.otelName)
+			t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "")
+
+			res, err := newResource(context.Background(), "instance-1")
+			if err != nil {
+				t.Fatalf("newResource() error = %v", err)
+			}
+			got, ok := res.Set().Value(semconv.ServiceNameKey)
+			if !ok || got.AsString() != tt.want {
+				t.Errorf("service.name = %q, want %q", got.AsString(), tt.want)
+			}
+			if got, _ := res.Set().Value("instance.id"); got.AsString() != "instance-1" {
+				t.Errorf("instance.id = %q, want instance-1", got.AsString())
+			}
+			if _, ok := res.Set().Value(semconv.ServiceVersionKey); !ok {
+				t.Error("service.version is missing")
+			}
+		})
+	}
+}
+
+func TestNewInstanceID(t *testing.T) {
+	t.Setenv("GAE_INSTANCE", "00c61b117c1f")
+	t.Setenv("OTEL_RESOURCE_ATTRIBUTES", "")
+
+	res, err := newResource(context.Background(), newInstanceID())
+	if err != nil {
+		t.Fatalf("newResource() error = %v", err)
+	}
+	if got, _ := res.Set().Value("instance.id"); got.AsString() != "00c61b117c1f" {
+		t.Errorf("instance.id = %q, want GAE_INSTANCE", got.AsString())
+	}
+}
+
+func TestNewInstanceIDFallback(t *testing.T) {
+	t.Setenv("GAE_INSTANCE", "")
+
+	id := newInstanceID()
+
+	if _, err := uuid.Parse(id); err != nil {
+		t.Errorf("instance ID = %q, want a UUID outside App Engine: %v", id, err)
+	}
+	if other := newInstanceID(); other == id {
+		t.Errorf("instance IDs = %q twice, want a new one for each process", id)
+	}
+}
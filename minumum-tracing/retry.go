@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// retryWithBackoff calls fn up to attempts times, doubling the delay after
+// each failure starting from base. It returns the last error once the
+// attempts are used up or ctx is done.
+func retryWithBackoff(ctx context.Context, name string, attempts int, base time.Duration, fn func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	delay := base
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt >= attempts {
+			return err
+		}
+
+		slog.Warn("attempt failed, retrying",
+			"operation", name,
+			"attempt", attempt,
+			"max_attempts", attempts,
+			"retry_in", delay.String(),
+			"error", err,
+		)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
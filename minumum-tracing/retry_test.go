@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var errCollectorUnreachable = errors.New("collector unreachable")
+
+// flakyExporterFactory returns an exporter factory that fails the first
+// failures calls, and counts the calls in calls.
+func flakyExporterFactory(failures int, calls *int) func() (sdktrace.SpanExporter, error) {
+	return func() (sdktrace.SpanExporter, error) {
+		*calls++
+		if *calls <= failures {
+			return nil, errCollectorUnreachable
+		}
+		return tracetest.NewInMemoryExporter(), nil
+	}
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		attempts  int
+		wantErr   error
+		wantCalls int
+	}{
+		{"first attempt", 0, 3, nil, 1},
+		{"transient failures", 2, 3, nil, 3},
+		{"attempts used up", 3, 3, errCollectorUnreachable, 3},
+		{"no retry", 1, 0, errCollectorUnreachable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			factory := flakyExporterFactory(tt.failures, &calls)
+			var exp sdktrace.SpanExporter
+			err := retryWithBackoff(context.Background(), "create span exporter", tt.attempts, time.Millisecond, func() error {
+				var err error
+				exp, err = factory()
+				return err
+			})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("retryWithBackoff() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("factory called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantErr == nil && exp == nil {
+				t.Error("no exporter created")
+			}
+		})
+	}
+}
+
+func TestRetryWithBackoffCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	factory := flakyExporterFactory(10, &calls)
+
+	err := retryWithBackoff(ctx, "create span exporter", 10, time.Hour, func() error {
+		_, err := factory()
+		return err
+	})
+
+	if !errors.Is(err, errCollectorUnreachable) || calls != 1 {
+		t.Errorf("retryWithBackoff() = %v after %d calls, want the first error without waiting", err, calls)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newSampler builds the sampler named like OTEL_TRACES_SAMPLER, with ratio
+// used by the traceidratio variants. always_on suits local development.
+func newSampler(name string, ratio float64) (sdktrace.Sampler, error) {
+	switch name {
+	case "", "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, fmt.Errorf("unsupported OTEL_TRACES_SAMPLER %q", name)
+	}
+}
+
+// reloadableSampler is the sampler named like OTEL_TRACES_SAMPLER, whose
+// ratio can be changed while the server runs.
+type reloadableSampler struct {
+	name    string
+	current atomic.Pointer[ratioSampler]
+}
+
+type ratioSampler struct {
+	sdktrace.Sampler
+	ratio float64
+}
+
+func newReloadableSampler(name string, ratio float64) (*reloadableSampler, error) {
+	s := &reloadableSampler{name: name}
+	if err := s.setRatio(ratio); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// setRatio replaces the sampler with one using ratio. Spans already started
+// keep the decision they were given.
+func (s *reloadableSampler) setRatio(ratio float64) error {
+	sampler, err := newSampler(s.name, ratio)
+	if err != nil {
+		return err
+	}
+	s.current.Store(&ratioSampler{Sampler: sampler, ratio: ratio})
+	return nil
+}
+
+func (s *reloadableSampler) ratio() float64 {
+	return s.current.Load().ratio
+}
+
+func (s *reloadableSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	return s.current.Load().ShouldSample(p)
+}
+
+func (s *reloadableSampler) Description() string {
+	return s.current.Load().Description()
+}
+
+// forceTraceHeader lets a developer capture the trace of a specific request
+// even when the sampler would drop it.
+const forceTraceHeader = "X-Force-Trace"
+
+// traceQueryParam does the same from a browser: ?trace=1 forces the request
+// to be traced and ?trace=0 keeps it out of the traces.
+const traceQueryParam = "trace"
+
+type forceTraceKey struct{}
+
+// withForceTrace marks ctx so that spans started from it are sampled.
+func withForceTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forceTraceKey{}, true)
+}
+
+func forceTraceFromContext(ctx context.Context) bool {
+	forced, _ := ctx.Value(forceTraceKey{}).(bool)
+	return forced
+}
+
+type suppressTraceKey struct{}
+
+// withSuppressTrace marks ctx so that spans started from it are dropped,
+// unless it is also marked by withForceTrace.
+func withSuppressTrace(ctx context.Context) context.Context {
+	return context.WithValue(ctx, suppressTraceKey{}, true)
+}
+
+func suppressTraceFromContext(ctx context.Context) bool {
+	suppressed, _ := ctx.Value(suppressTraceKey{}).(bool)
+	return suppressed
+}
+
+// forceTraceSampler samples spans whose context was marked by withForceTrace,
+// drops those marked by withSuppressTrace and defers to base for the others.
+type forceTraceSampler struct {
+	base sdktrace.Sampler
+}
+
+func (s forceTraceSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if !forceTraceFromContext(p.ParentContext) {
+		if suppressTraceFromContext(p.ParentContext) {
+			return sdktrace.SamplingResult{
+				Decision:   sdktrace.Drop,
+				Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+			}
+		}
+		return s.base.ShouldSample(p)
+	}
+	return sdktrace.SamplingResult{
+		Decision:   sdktrace.RecordAndSample,
+		Attributes: []attribute.KeyValue{attribute.Bool("sampling.forced", true)},
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+}
+
+func (s forceTraceSampler) Description() string {
+	return "ForceTrace{" + s.base.Description() + "}"
+}
+
+// routeFilterSampler drops spans started for the given route templates, such
+// as infrastructure endpoints polled too often to be worth tracing, and defers
+// to base for the others. It relies on the tracing middleware setting
+// http.route when the span starts; spans started below a dropped one are
+// dropped with it.
+type routeFilterSampler struct {
+	base   sdktrace.Sampler
+	routes map[string]bool
+}
+
+func newRouteFilterSampler(base sdktrace.Sampler, routes []string) routeFilterSampler {
+	s := routeFilterSampler{base: base, routes: make(map[string]bool, len(routes))}
+	for _, r := range routes {
+		s.routes[r] = true
+	}
+	return s
+}
+
+func (s routeFilterSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if localParentDropped(p) {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.Drop,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	for _, kv := range p.Attributes {
+		if kv.Key == "http.route" && s.routes[kv.Value.AsString()] {
+			return sdktrace.SamplingResult{
+				Decision:   sdktrace.Drop,
+				Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+			}
+		}
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s routeFilterSampler) Description() string {
+	routes := make([]string, 0, len(s.routes))
+	for r := range s.routes {
+		routes = append(routes, r)
+	}
+	sort.Strings(routes)
+	return fmt.Sprintf("RouteFilter{%s,ignored=%v}", s.base.Description(), routes)
+}
+
+// localParentDropped reports whether the parent of the span being sampled was
+// started in this process and not sampled. Its descendants follow it rather
+// than being exported as orphans of a span that never is; the base sampler
+// may be always_on, which ignores the parent.
+func localParentDropped(p sdktrace.SamplingParameters) bool {
+	psc := trace.SpanContextFromContext(p.ParentContext)
+	return psc.IsValid() && !psc.IsRemote() && !psc.IsSampled()
+}
+
+// samplingRule samples the routes matching pattern at ratio. A pattern
+// ending in * matches every route template starting with the rest of it;
+// any other pattern matches one route template exactly.
+type samplingRule struct {
+	pattern string
+	ratio   float64
+}
+
+func (r samplingRule) String() string {
+	return fmt.Sprintf("%s:%g", r.pattern, r.ratio)
+}
+
+// MarshalText lets the rules show up in the JSON startup log.
+func (r samplingRule) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r samplingRule) matches(route string) bool {
+	if prefix, ok := strings.CutSuffix(r.pattern, "*"); ok {
+		return strings.HasPrefix(route, prefix)
+	}
+	return route == r.pattern
+}
+
+// parseSamplingRules parses a comma-separated list of route:ratio pairs, such
+// as SAMPLING_RULES="/echo/*:0.1,/important:1".
+func parseSamplingRules(v string) ([]samplingRule, error) {
+	var rules []samplingRule
+	for _, item := range parseList(v) {
+		i := strings.LastIndex(item, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid SAMPLING_RULES entry %q: must be route:ratio", item)
+		}
+		ratio, err := strconv.ParseFloat(item[i+1:], 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid SAMPLING_RULES entry %q: ratio must be a number between 0 and 1", item)
+		}
+		rules = append(rules, samplingRule{pattern: item[:i], ratio: ratio})
+	}
+	return rules, nil
+}
+
+// routeRatioSampler samples spans started for a route by the ratio of the
+// first rule matching it, and defers to base for the others. Like
+// routeFilterSampler, it relies on http.route being set when the span
+// starts; spans started below a dropped one are dropped with it.
+type routeRatioSampler struct {
+	base     sdktrace.Sampler
+	rules    []samplingRule
+	samplers []sdktrace.Sampler
+}
+
+func newRouteRatioSampler(base sdktrace.Sampler, rules []samplingRule) sdktrace.Sampler {
+	if len(rules) == 0 {
+		return base
+	}
+	s := routeRatioSampler{base: base, rules: rules}
+	for _, r := range rules {
+		s.samplers = append(s.samplers, sdktrace.TraceIDRatioBased(r.ratio))
+	}
+	return s
+}
+
+func (s routeRatioSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	if localParentDropped(p) {
+		return sdktrace.SamplingResult{
+			Decision:   sdktrace.Drop,
+			Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+		}
+	}
+	for _, kv := range p.Attributes {
+		if kv.Key != "http.route" {
+			continue
+		}
+		for i, r := range s.rules {
+			if r.matches(kv.Value.AsString()) {
+				return s.samplers[i].ShouldSample(p)
+			}
+		}
+		break
+	}
+	return s.base.ShouldSample(p)
+}
+
+func (s routeRatioSampler) Description() string {
+	rules := make([]string, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, r.String())
+	}
+	return fmt.Sprintf("RouteRatio{%s,rules=%v}", s.base.Description(), rules)
+}
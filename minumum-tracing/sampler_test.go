@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestForceTrace(t *testing.T) {
+	t.Setenv("OTEL_TRACES_SAMPLER", "traceidratio")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+	if n := len(exp.GetSpans()); n != 0 {
+		t.Fatalf("recorded %d spans without X-Force-Trace, want none with a zero ratio", n)
+	}
+
+	r := httptest.NewRequest("GET", "/echo/hi", nil)
+	r.Header.Set(forceTraceHeader, "true")
+	serve(s, r)
+
+	findSpan(t, exp, "echo-handler")
+	for _, span := range exp.GetSpans() {
+		if span.SpanKind != trace.SpanKindServer {
+			continue
+		}
+		if v, _ := spanAttr(span, "sampling.forced"); !v.AsBool() {
+			t.Errorf("server span %q lacks sampling.forced", span.Name)
+		}
+		return
+	}
+	t.Errorf("no server span among %d recorded spans", len(exp.GetSpans()))
+}
+
+func TestSamplingRulesDropChildSpans(t *testing.T) {
+	// The default always_on sampler ignores the parent, so the child spans
+	// of a route sampled at 0 would be exported as orphans.
+	t.Setenv("SAMPLING_RULES", "/store/*:0,/echo/{message}/nested:0")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("PUT", "/store/k", strings.NewReader(`{"message":"hi"}`)))
+	serve(s, httptest.NewRequest("GET", "/store/k", nil))
+	serve(s, httptest.NewRequest("GET", "/echo/hi/nested", nil))
+	for _, span := range exp.GetSpans() {
+		t.Errorf("recorded span %q for a route sampled at 0, want none", span.Name)
+	}
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi/stream", nil))
+	if stream := findSpan(t, exp, "echo-stream"); !stream.Parent.IsValid() {
+		t.Error("echo-stream has no parent, want the sampled handler span")
+	}
+}
+
+func TestRouteRatioSamplerFollowsLocalParent(t *testing.T) {
+	sampler := newRouteRatioSampler(sdktrace.AlwaysSample(), []samplingRule{{pattern: "/echo/*", ratio: 0}})
+	tests := []struct {
+		name   string
+		parent trace.SpanContext
+		want   sdktrace.SamplingDecision
+	}{
+		{"unsampled local parent", testSpanContext.WithTraceFlags(0), sdktrace.Drop},
+		{"sampled local parent", testSpanContext, sdktrace.RecordAndSample},
+		{"unsampled remote parent", testSpanContext.WithTraceFlags(0).WithRemote(true), sdktrace.RecordAndSample},
+	}
+	for _, tt := range tests {
+		p := sdktrace.SamplingParameters{
+			ParentContext: trace.ContextWithSpanContext(context.Background(), tt.parent),
+			TraceID:       tt.parent.TraceID(),
+			Name:          "child",
+		}
+		if got := sampler.ShouldSample(p).Decision; got != tt.want {
+			t.Errorf("%s: decision = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTraceQueryParam(t *testing.T) {
+	tests := []struct {
+		ratio, query string
+		wantSpans    bool
+	}{
+		{"0", "", false},
+		{"1", "", true},
+		{"0", "?trace=1", true},
+		{"1", "?trace=0", false},
+	}
+	for _, tt := range tests {
+		t.Run("ratio="+tt.ratio+tt.query, func(t *testing.T) {
+			t.Setenv("OTEL_TRACES_SAMPLER", "traceidratio")
+			t.Setenv("OTEL_TRACES_SAMPLER_ARG", tt.ratio)
+			s, exp := newTestServer(t, testConfig(t))
+
+			w := serve(s, httptest.NewRequest("GET", "/echo/hi"+tt.query, nil))
+
+			if got := len(exp.GetSpans()) > 0; got != tt.wantSpans {
+				t.Errorf("recorded spans = %t, want %t", got, tt.wantSpans)
+			}
+			if got := w.Header().Get(traceSampledHeader); got != strconv.FormatBool(tt.wantSpans) {
+				t.Errorf("%s = %q, want %t", traceSampledHeader, got, tt.wantSpans)
+			}
+		})
+	}
+}
+
+func TestUntracedRoutes(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	serve(s, httptest.NewRequest("GET", warmupPath, nil))
+	findSpan(t, exp, "warmup")
+
+	t.Setenv("UNTRACED_ROUTES", warmupPath)
+	s, exp = newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", warmupPath, nil))
+	if spans := exp.GetSpans(); len(spans) != 0 {
+		t.Errorf("recorded %d spans for %s, want none for an untraced route", len(spans), warmupPath)
+	}
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+	findSpan(t, exp, "echo-handler")
+}
+
+func TestUntracedRoutesDropChildSpans(t *testing.T) {
+	t.Setenv("UNTRACED_ROUTES", "/echo/{message}/nested")
+	s, exp := newTestServer(t, testConfig(t))
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi/nested", nil))
+
+	// validate and transform would otherwise be exported as orphans of the
+	// dropped handler span.
+	for _, span := range exp.GetSpans() {
+		t.Errorf("recorded span %q for an untraced route, want none", span.Name)
+	}
+}
+
+func TestSamplingRules(t *testing.T) {
+	// The base sampler drops everything, so the /version spans are only
+	// recorded by following their rule.
+	t.Setenv("OTEL_TRACES_SAMPLER", "traceidratio")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "0")
+	t.Setenv("SAMPLING_RULES", "/echo/*:0,/version:1")
+	s, exp := newTestServer(t, testConfig(t))
+
+	for range 10 {
+		serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+		serve(s, httptest.NewRequest("GET", "/version", nil))
+	}
+
+	routes := map[string]int{}
+	for _, span := range exp.GetSpans() {
+		if v, ok := spanAttr(span, "http.route"); ok {
+			routes[v.AsString()]++
+		}
+	}
+	if routes["/echo/{message}"] != 0 {
+		t.Errorf("sampled %d /echo/{message} spans, want none at ratio 0", routes["/echo/{message}"])
+	}
+	if routes["/version"] != 10 {
+		t.Errorf("sampled %d /version spans, want all 10 at ratio 1", routes["/version"])
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// ShutdownFunc releases a resource during graceful shutdown.
+type ShutdownFunc func(ctx context.Context) error
+
+type shutdownHook struct {
+	name string
+	fn   ShutdownFunc
+}
+
+// shutdownHooks runs registered ShutdownFuncs in reverse registration order,
+// so resources are torn down in the opposite order they were set up.
+type shutdownHooks struct {
+	hooks []shutdownHook
+
+	once sync.Once
+	err  error
+}
+
+func (h *shutdownHooks) register(name string, fn ShutdownFunc) {
+	h.hooks = append(h.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// run calls every hook with a shared deadline of timeout, continuing past
+// failures, and returns the joined errors. The deadline starts when the hooks
+// do, rather than coming from the caller, whose context the HTTP drain may
+// already have used up. The hooks run only once: later calls, including
+// concurrent ones, wait for the first to finish, log a warning and return
+// its result, so that providers are never flushed or shut down twice.
+func (h *shutdownHooks) run(timeout time.Duration) error {
+	first := false
+	h.once.Do(func() {
+		first = true
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		h.err = h.runAll(ctx)
+	})
+	if !first {
+		slog.Warn("shutdown hooks already ran, ignoring duplicate shutdown")
+	}
+	return h.err
+}
+
+func (h *shutdownHooks) runAll(ctx context.Context) error {
+	var errs []error
+	for i := len(h.hooks) - 1; i >= 0; i-- {
+		hook := h.hooks[i]
+		start := time.Now()
+		if err := hook.fn(ctx); err != nil {
+			slog.Error("shutdown hook failed", "hook", hook.name, "error", err, "elapsed", time.Since(start).String())
+			errs = append(errs, err)
+			continue
+		}
+		slog.Info("shutdown hook completed", "hook", hook.name, "elapsed", time.Since(start).String())
+	}
+	return errors.Join(errs...)
+}
+
+type flushShutdowner interface {
+	ForceFlush(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+}
+
+// flushAndShutdown flushes pending telemetry before shutting p down.
+func flushAndShutdown(p flushShutdowner) ShutdownFunc {
+	return func(ctx context.Context) error {
+		return errors.Join(p.ForceFlush(ctx), p.Shutdown(ctx))
+	}
+}
+
+// forceExitOnSignal calls exit with status 1 once a signal arrives on sigs. It
+// is started after the first signal, so that a second one cuts a slow drain
+// short.
+func forceExitOnSignal(sigs <-chan os.Signal, exit func(code int)) {
+	sig := <-sigs
+	slog.Warn("received second signal during shutdown, forcing exit", "signal", sig.String())
+	exit(1)
+}
+
+// logShutdownSummary records the end of the instance's life: why it stopped,
+// how many requests it served, how long it ran and whether the shutdown
+// completed cleanly.
+func (s *server) logShutdownSummary(reason string, clean bool) {
+	slog.Info("shutdown summary",
+		"reason", reason,
+		"requests_served", s.requestsServed.Load(),
+		"uptime", time.Since(s.startTime).Round(time.Millisecond).String(),
+		"clean", clean,
+	)
+}
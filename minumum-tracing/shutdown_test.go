@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownHooks(t *testing.T) {
+	var (
+		hooks shutdownHooks
+		order []string
+	)
+	record := func(name string, err error) ShutdownFunc {
+		return func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok || ctx.Err() != nil {
+				t.Errorf("hook %s got a context without a live deadline", name)
+			}
+			order = append(order, name)
+			return err
+		}
+	}
+	failure := errors.New("flush failed")
+	hooks.register("tracer provider", record("tracer provider", nil))
+	hooks.register("meter provider", record("meter provider", failure))
+	hooks.register("background tasks", record("background tasks", nil))
+
+	err := hooks.run(time.Second)
+
+	if !errors.Is(err, failure) {
+		t.Errorf("run() error = %v, want %v", err, failure)
+	}
+	want := []string{"background tasks", "meter provider", "tracer provider"}
+	if !slices.Equal(order, want) {
+		t.Errorf("order = %q, want %q", order, want)
+	}
+
+	if err := hooks.run(time.Second); !errors.Is(err, failure) {
+		t.Errorf("second run() error = %v, want the first result", err)
+	}
+	if len(order) != len(want) {
+		t.Errorf("hooks ran %d times, want once each", len(order))
+	}
+}
+
+// countingProvider counts the calls flushAndShutdown makes, taking a while to
+// flush so that concurrent shutdowns overlap.
+type countingProvider struct {
+	flushes, shutdowns atomic.Int32
+}
+
+func (p *countingProvider) ForceFlush(context.Context) error {
+	p.flushes.Add(1)
+	time.Sleep(20 * time.Millisecond)
+	return nil
+}
+
+func (p *countingProvider) Shutdown(context.Context) error {
+	p.shutdowns.Add(1)
+	return nil
+}
+
+func TestShutdownHooksConcurrent(t *testing.T) {
+	logs := captureLogs(t)
+	var (
+		hooks    shutdownHooks
+		provider countingProvider
+	)
+	hooks.register("tracer provider", flushAndShutdown(&provider))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = hooks.run(time.Second)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("run() #%d error = %v", i, err)
+		}
+	}
+	if provider.flushes.Load() != 1 || provider.shutdowns.Load() != 1 {
+		t.Errorf("flushed %d and shut down %d times, want once each", provider.flushes.Load(), provider.shutdowns.Load())
+	}
+	if n := countLogs(logs(), "shutdown hooks already ran, ignoring duplicate shutdown"); n != 1 {
+		t.Errorf("logged %d duplicate shutdowns, want 1", n)
+	}
+}
+
+func TestShutdownSummary(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	s.startTime = time.Now().Add(-time.Minute)
+	for range 3 {
+		serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+	}
+	logs := captureLogs(t)
+
+	s.logShutdownSummary("terminated", true)
+
+	entry := findLog(t, logs(), "shutdown summary")
+	if entry["requests_served"] != 3.0 {
+		t.Errorf("requests_served = %v, want 3", entry["requests_served"])
+	}
+	if entry["reason"] != "terminated" || entry["clean"] != true {
+		t.Errorf("reason, clean = %v, %v, want terminated, true", entry["reason"], entry["clean"])
+	}
+	if uptime, err := time.ParseDuration(entry["uptime"].(string)); err != nil || uptime < time.Minute {
+		t.Errorf("uptime = %v, want at least 1m", entry["uptime"])
+	}
+}
+
+func TestForceExitOnSecondSignal(t *testing.T) {
+	logs := captureLogs(t)
+	sigs := make(chan os.Signal, 2)
+	exited := make(chan int, 1)
+
+	sigs <- os.Interrupt
+	<-sigs // taken by the shutdown goroutine to start draining
+	go forceExitOnSignal(sigs, func(code int) { exited <- code })
+
+	select {
+	case code := <-exited:
+		t.Fatalf("exited with %d before the second signal", code)
+	case <-time.After(20 * time.Millisecond):
+	}
+	sigs <- syscall.SIGTERM
+
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Errorf("exit code = %d, want 1", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no exit after the second signal")
+	}
+	entry := findLog(t, logs(), "received second signal during shutdown, forcing exit")
+	if entry["signal"] != syscall.SIGTERM.String() {
+		t.Errorf("signal = %v, want %s", entry["signal"], syscall.SIGTERM)
+	}
+}
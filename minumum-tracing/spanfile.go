@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// fileExporter writes spans as JSON lines to a file, for capturing them where
+// no collector is reachable, such as offline or in CI.
+type fileExporter struct {
+	*stdouttrace.Exporter
+	f *os.File
+}
+
+// newFileExporter appends spans to the file at path, creating it if needed.
+func newFileExporter(path string) (sdktrace.SpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open span file: %w", err)
+	}
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fileExporter{Exporter: exp, f: f}, nil
+}
+
+// Shutdown stops the exporter, then syncs and closes the file so that every
+// exported span is on disk.
+func (e fileExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.Exporter.Shutdown(ctx), e.f.Sync(), e.f.Close())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Defaults matching those of the batch span processor for
+// OTEL_BSP_MAX_QUEUE_SIZE, OTEL_BSP_MAX_EXPORT_BATCH_SIZE,
+// OTEL_BSP_SCHEDULE_DELAY and OTEL_BSP_EXPORT_TIMEOUT.
+const (
+	defaultSpanQueueSize     = 2048
+	defaultSpanBatchSize     = 512
+	defaultSpanBatchDelay    = 5 * time.Second
+	defaultSpanExportTimeout = 30 * time.Second
+)
+
+// SpanQueueResponse is the response of GET /metrics/healthz.
+type SpanQueueResponse struct {
+	Queued   int64 `json:"queued"`
+	Dropped  int64 `json:"dropped"`
+	Capacity int64 `json:"capacity"`
+}
+
+// queueMonitor counts the sampled spans handed to the batch span processor
+// until they are exported. The processor silently drops spans while its
+// queue is full, so queueMonitor drops them itself once capacity spans are
+// pending, which lets it count them. Since it also counts the batch being
+// exported, it gives up on a span no later than the processor would.
+type queueMonitor struct {
+	sdktrace.SpanProcessor
+	capacity int64
+	health   *exportHealth
+}
+
+func (m queueMonitor) OnEnd(s sdktrace.ReadOnlySpan) {
+	if !s.SpanContext().IsSampled() {
+		m.SpanProcessor.OnEnd(s)
+		return
+	}
+	if m.health.queued.Add(1) > m.capacity {
+		m.health.queued.Add(-1)
+		m.health.dropped.Add(1)
+		return
+	}
+	m.SpanProcessor.OnEnd(s)
+}
+
+// newQueueMonitor wraps a batch span processor exporting to exp, which must
+// report to health, configured with the OTEL_BSP_* settings of cfg.
+func newQueueMonitor(exp sdktrace.SpanExporter, cfg Config, health *exportHealth) sdktrace.SpanProcessor {
+	slog.Info("span batching configured",
+		"max_queue_size", cfg.SpanQueueSize,
+		"max_export_batch_size", cfg.SpanBatchSize,
+		"schedule_delay", cfg.SpanBatchDelay.String(),
+		"export_timeout", cfg.SpanExportTimeout.String(),
+	)
+	return queueMonitor{
+		SpanProcessor: sdktrace.NewBatchSpanProcessor(exp,
+			sdktrace.WithMaxQueueSize(cfg.SpanQueueSize),
+			sdktrace.WithMaxExportBatchSize(cfg.SpanBatchSize),
+			sdktrace.WithBatchTimeout(cfg.SpanBatchDelay),
+			sdktrace.WithExportTimeout(cfg.SpanExportTimeout),
+		),
+		capacity: int64(cfg.SpanQueueSize),
+		health:   health,
+	}
+}
+
+// handleSpanQueue reports how many spans are waiting to be exported and how
+// many were dropped because the queue was full. It is only registered when
+// spans are exported.
+func (s *server) handleSpanQueue(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(r.Context(), w, SpanQueueResponse{
+		Queued:   s.exportHealth.queued.Load(),
+		Dropped:  s.exportHealth.dropped.Load(),
+		Capacity: int64(s.spanQueueSize),
+	})
+}
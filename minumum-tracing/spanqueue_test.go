@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// blockingExporter is a span exporter whose exports wait until release is
+// closed, as with a collector that stopped answering.
+type blockingExporter struct {
+	*tracetest.InMemoryExporter
+	release chan struct{}
+}
+
+func (e blockingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	<-e.release
+	return e.InMemoryExporter.ExportSpans(ctx, spans)
+}
+
+func TestSpanQueue(t *testing.T) {
+	t.Setenv("OTEL_BSP_MAX_QUEUE_SIZE", "4")
+	t.Setenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "1")
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "1")
+	cfg := testConfig(t)
+	health := new(exportHealth)
+	blocked := blockingExporter{tracetest.NewInMemoryExporter(), make(chan struct{})}
+	exp := monitoredExporter{SpanExporter: blocked, health: health}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(newQueueMonitor(exp, cfg, health)))
+	s, err := newServer(cfg, new(slog.LevelVar), health, nil)
+	if err != nil {
+		t.Fatalf("newServer() error = %v", err)
+	}
+
+	for range 10 {
+		_, span := tp.Tracer("test").Start(context.Background(), "work")
+		span.End()
+	}
+	w := serve(s, httptest.NewRequest("GET", "/metrics/healthz", nil))
+
+	var got SpanQueueResponse
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if want := (SpanQueueResponse{Queued: 4, Dropped: 6, Capacity: 4}); got != want {
+		t.Errorf("span queue = %+v, want %+v while the exporter is stuck", got, want)
+	}
+
+	close(blocked.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tp.ForceFlush(ctx); err != nil {
+		t.Fatalf("ForceFlush() error = %v", err)
+	}
+	defer tp.Shutdown(context.Background())
+	if n := health.queued.Load(); n != 0 {
+		t.Errorf("queued after the exporter recovered = %d, want 0", n)
+	}
+	if n := len(blocked.GetSpans()); n != 4 {
+		t.Errorf("exported %d spans, want the 4 that were queued", n)
+	}
+}
+
+// batchRecorder records the size of every batch exported.
+type batchRecorder struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (e *batchRecorder) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sizes = append(e.sizes, len(spans))
+	return nil
+}
+
+func (e *batchRecorder) Shutdown(context.Context) error { return nil }
+
+func (e *batchRecorder) exported() (total, largest int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, n := range e.sizes {
+		total += n
+		largest = max(largest, n)
+	}
+	return total, largest
+}
+
+func TestSpanBatchSettings(t *testing.T) {
+	t.Setenv("OTEL_BSP_MAX_QUEUE_SIZE", "16")
+	t.Setenv("OTEL_BSP_MAX_EXPORT_BATCH_SIZE", "2")
+	t.Setenv("OTEL_BSP_SCHEDULE_DELAY", "10")
+	t.Setenv("OTEL_BSP_EXPORT_TIMEOUT", "1500")
+	cfg := testConfig(t)
+	logs := captureLogs(t)
+	health := new(exportHealth)
+	batches := new(batchRecorder)
+	exp := monitoredExporter{SpanExporter: batches, health: health}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(newQueueMonitor(exp, cfg, health)))
+	defer tp.Shutdown(context.Background())
+
+	for range 5 {
+		_, span := tp.Tracer("test").Start(context.Background(), "work")
+		span.End()
+	}
+
+	// The default schedule delay of 5s would keep the spans queued for
+	// much longer than this.
+	deadline := time.Now().Add(time.Second)
+	for total, _ := batches.exported(); total < 5; total, _ = batches.exported() {
+		if time.Now().After(deadline) {
+			t.Fatalf("exported %d spans within 1s, want all 5 after the 10ms schedule delay", total)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, largest := batches.exported(); largest > 2 {
+		t.Errorf("exported a batch of %d spans, want at most 2", largest)
+	}
+	entry := findLog(t, logs(), "span batching configured")
+	want := map[string]any{
+		"max_queue_size":        16.0,
+		"max_export_batch_size": 2.0,
+		"schedule_delay":        "10ms",
+		"export_timeout":        "1.5s",
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("%s = %v, want %v", key, entry[key], value)
+		}
+	}
+}
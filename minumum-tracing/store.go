@@ -0,0 +1,165 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxStoreKeyLength bounds the memory a single store entry can hold in keys.
+const maxStoreKeyLength = 255
+
+// StoreResponse is the response of PUT and GET /store/{key}.
+type StoreResponse struct {
+	Key     string `json:"key"`
+	Message string `json:"message"`
+}
+
+// kvStore keeps the messages stored with PUT /store/{key} in memory. It holds
+// at most size entries, evicting the least recently used one. Entries are
+// lost when the instance stops.
+type kvStore struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List // of *storeEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type storeEntry struct {
+	key     string
+	message string
+}
+
+func newKVStore(size int) *kvStore {
+	return &kvStore{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// put stores message under key, replacing any previous one, and returns the
+// number of entries evicted to make room.
+func (s *kvStore) put(key, message string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		el.Value.(*storeEntry).message = message
+		s.order.MoveToFront(el)
+		return 0
+	}
+	s.entries[key] = s.order.PushFront(&storeEntry{key: key, message: message})
+	evicted := 0
+	for s.order.Len() > s.size {
+		el := s.order.Back()
+		s.order.Remove(el)
+		delete(s.entries, el.Value.(*storeEntry).key)
+		evicted++
+	}
+	return evicted
+}
+
+// get returns the message stored under key and marks it as recently used.
+func (s *kvStore) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.entries[key]
+	if !ok {
+		return "", false
+	}
+	s.order.MoveToFront(el)
+	return el.Value.(*storeEntry).message, true
+}
+
+// len returns the number of entries held.
+func (s *kvStore) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// handleStorePut stores the message of the request body under the key of the
+// path.
+func (s *server) handleStorePut(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key, ok := storeKey(w, r)
+	if !ok {
+		return
+	}
+
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if req.Message == "" {
+		writeJSONError(w, http.StatusBadRequest, errEmptyMessage.Error(), traceIDFromContext(ctx))
+		return
+	}
+	if !s.checkMessageLength(w, r, req.Message) {
+		return
+	}
+
+	s.storePut(ctx, key, req.Message)
+	s.writeJSON(ctx, w, s.withResponseField(StoreResponse{Key: key, Message: req.Message}))
+}
+
+// handleStoreGet answers the message stored under the key of the path, or
+// 404 when there is none.
+func (s *server) handleStoreGet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	key, ok := storeKey(w, r)
+	if !ok {
+		return
+	}
+
+	message, found := s.storeGet(ctx, key)
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "key not found", traceIDFromContext(ctx))
+		return
+	}
+	s.writeJSON(ctx, w, s.withResponseField(StoreResponse{Key: key, Message: message}))
+}
+
+// storeKey returns the key of the path, answering 400 if it is too long.
+func storeKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	key := r.PathValue("key")
+	if len(key) > maxStoreKeyLength {
+		writeJSONError(w, http.StatusBadRequest,
+			fmt.Sprintf("key exceeds %d bytes", maxStoreKeyLength), traceIDFromContext(r.Context()))
+		return "", false
+	}
+	return key, true
+}
+
+// storePut writes to the store in a child span recording the evictions.
+func (s *server) storePut(ctx context.Context, key, message string) {
+	_, span := s.tracer.Start(ctx, "store.put", trace.WithAttributes(attribute.String("store.key", key)))
+	defer span.End()
+
+	evicted := s.store.put(key, message)
+	span.SetAttributes(
+		attribute.Int("store.evicted", evicted),
+		attribute.Int("store.size", s.store.len()),
+	)
+}
+
+// storeGet reads from the store in a child span recording whether the key
+// was found.
+func (s *server) storeGet(ctx context.Context, key string) (string, bool) {
+	_, span := s.tracer.Start(ctx, "store.get", trace.WithAttributes(attribute.String("store.key", key)))
+	defer span.End()
+
+	message, found := s.store.get(key)
+	span.SetAttributes(attribute.Bool("store.hit", found))
+	return message, found
+}
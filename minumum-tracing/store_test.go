@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// putStore stores message under key through PUT /store/{key}.
+func putStore(t *testing.T, s http.Handler, key, message string) {
+	t.Helper()
+	w := serve(s, httptest.NewRequest("PUT", "/store/"+key, strings.NewReader(`{"message":"`+message+`"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT /store/%s status = %d, want %d", key, w.Code, http.StatusOK)
+	}
+}
+
+func TestStorePutGet(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	putStore(t, s, "k", "hi")
+	exp.Reset()
+	w := serve(s, httptest.NewRequest("GET", "/store/k", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	var resp StoreResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp != (StoreResponse{Key: "k", Message: "hi"}) {
+		t.Errorf("response = %+v (%v), want hi under k", resp, err)
+	}
+	if v, _ := spanAttr(findSpan(t, exp, "store.get"), "store.hit"); !v.AsBool() {
+		t.Error("store.hit = false, want true")
+	}
+}
+
+func TestStoreGetUnknownKey(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("GET", "/store/missing", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	v, ok := spanAttr(findSpan(t, exp, "store.get"), "store.hit")
+	if !ok || v.AsBool() {
+		t.Errorf("store.hit = %v (set %t), want false", v.AsBool(), ok)
+	}
+}
+
+func TestStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Setenv("STORE_SIZE", "2")
+	s, exp := newTestServer(t, testConfig(t))
+
+	putStore(t, s, "a", "1")
+	putStore(t, s, "b", "2")
+	exp.Reset()
+	putStore(t, s, "c", "3")
+
+	if v, _ := spanAttr(findSpan(t, exp, "store.put"), "store.evicted"); v.AsInt64() != 1 {
+		t.Errorf("store.evicted = %d, want 1", v.AsInt64())
+	}
+	for key, want := range map[string]int{"a": http.StatusNotFound, "b": http.StatusOK, "c": http.StatusOK} {
+		if w := serve(s, httptest.NewRequest("GET", "/store/"+key, nil)); w.Code != want {
+			t.Errorf("GET /store/%s status = %d, want %d", key, w.Code, want)
+		}
+	}
+}
+
+func TestStoreGetRefreshesKey(t *testing.T) {
+	t.Setenv("STORE_SIZE", "2")
+	s, _ := newTestServer(t, testConfig(t))
+
+	putStore(t, s, "a", "1")
+	putStore(t, s, "b", "2")
+	if w := serve(s, httptest.NewRequest("GET", "/store/a", nil)); w.Code != http.StatusOK {
+		t.Fatalf("GET /store/a status = %d, want %d", w.Code, http.StatusOK)
+	}
+	putStore(t, s, "c", "3")
+
+	for key, want := range map[string]int{"a": http.StatusOK, "b": http.StatusNotFound, "c": http.StatusOK} {
+		if w := serve(s, httptest.NewRequest("GET", "/store/"+key, nil)); w.Code != want {
+			t.Errorf("GET /store/%s status = %d, want %d", key, w.Code, want)
+		}
+	}
+}
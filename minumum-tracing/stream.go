@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// streamChunkDelay is the pause between characters written by handleEchoStream.
+const streamChunkDelay = 50 * time.Millisecond
+
+// processingTimeTrailer reports how long the stream took, which is only known
+// once the body has been written.
+const processingTimeTrailer = "X-Processing-Time"
+
+// handleEchoStream writes the message back one character at a time, flushing
+// after each one so that clients observe a chunked response. When the
+// response writer cannot flush, the message is buffered and sent at once
+// instead. The processing time is sent in a trailer; clients that do not
+// read trailers simply never see it, and it is recorded on the span either
+// way.
+func (s *server) handleEchoStream(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	message := r.PathValue("message")
+	if !s.checkMessageLength(w, r, message) {
+		return
+	}
+
+	ctx, span := s.tracer.Start(ctx, "echo-stream")
+	defer span.End()
+
+	start := time.Now()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Trailer", processingTimeTrailer)
+	rc := http.NewResponseController(w)
+	flushable := true
+	chunks := 0
+	defer func() {
+		elapsed := time.Since(start)
+		w.Header().Set(processingTimeTrailer, elapsed.String())
+		span.SetAttributes(
+			attribute.Int("stream.chunks", chunks),
+			attribute.Bool("stream.flushed", flushable),
+			attribute.Float64("stream.processing_ms", float64(elapsed.Microseconds())/1000),
+		)
+	}()
+
+	for _, c := range message {
+		if _, err := w.Write([]byte(string(c))); isClientDisconnect(err) {
+			span.AddEvent("client_disconnected")
+			return
+		} else if err != nil {
+			span.RecordError(err)
+			return
+		}
+		chunks++
+
+		if flushable {
+			if err := rc.Flush(); errors.Is(err, http.ErrNotSupported) {
+				// The response is still written in full, just not
+				// incrementally, for instance behind a wrapper that cannot
+				// flush.
+				flushable = false
+				span.SetAttributes(attribute.Bool("streaming.unsupported", true))
+				slog.WarnContext(ctx, "response writer does not support flushing, buffering the stream")
+			} else if isClientDisconnect(err) {
+				span.AddEvent("client_disconnected")
+				return
+			} else if err != nil {
+				span.RecordError(err)
+				return
+			}
+		}
+		if !flushable {
+			// Pacing a buffered response only delays it.
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			span.AddEvent("client_disconnected")
+			return
+		case <-time.After(streamChunkDelay):
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEchoStreamTrailer(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/echo/abc/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "abc" {
+		t.Errorf("body = %q, want abc", body)
+	}
+	// Trailers are only known once the body has been read.
+	elapsed, err := time.ParseDuration(resp.Trailer.Get(processingTimeTrailer))
+	if err != nil || elapsed < 2*streamChunkDelay {
+		t.Errorf("%s trailer = %q (%v), want the time taken by the stream", processingTimeTrailer, resp.Trailer.Get(processingTimeTrailer), err)
+	}
+	span := findSpan(t, exp, "echo-stream")
+	if v, _ := spanAttr(span, "stream.processing_ms"); v.AsFloat64() < float64(2*streamChunkDelay/time.Millisecond) {
+		t.Errorf("stream.processing_ms = %v, want the time taken by the stream", v.AsFloat64())
+	}
+	if v, _ := spanAttr(span, "stream.chunks"); v.AsInt64() != 3 {
+		t.Errorf("stream.chunks = %d, want 3", v.AsInt64())
+	}
+}
+
+// nonFlushingWriter hides the Flush method of the writer it wraps, like a
+// proxy that buffers responses.
+type nonFlushingWriter struct {
+	http.ResponseWriter
+}
+
+func TestEchoStreamWithoutFlush(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	logs := captureLogs(t)
+	r := httptest.NewRequest("GET", "/echo/abc/stream", nil)
+	r.SetPathValue("message", "abc")
+	rec := httptest.NewRecorder()
+
+	start := time.Now()
+	s.handleEchoStream(nonFlushingWriter{rec}, r)
+
+	if rec.Body.String() != "abc" {
+		t.Errorf("body = %q, want the whole message buffered", rec.Body)
+	}
+	if rec.Flushed {
+		t.Error("response flushed through a writer that cannot flush")
+	}
+	if elapsed := time.Since(start); elapsed >= streamChunkDelay {
+		t.Errorf("stream took %s, want no pacing while buffering", elapsed)
+	}
+	span := findSpan(t, exp, "echo-stream")
+	if v, _ := spanAttr(span, "streaming.unsupported"); !v.AsBool() {
+		t.Error("streaming.unsupported not set on the span")
+	}
+	findLog(t, logs(), "response writer does not support flushing, buffering the stream")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"go.opentelemetry.io/contrib/exporters/autoexport"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// setupTracing creates the configured span exporter and installs a tracer
+// provider exporting to it. Creating the exporter is retried, backing off
+// between attempts, since the collector may still be starting. Export
+// outcomes and the export queue are reported to health.
+func setupTracing(ctx context.Context, res *resource.Resource, cfg Config, base sdktrace.Sampler, health *exportHealth) (*sdktrace.TracerProvider, error) {
+	var exp sdktrace.SpanExporter
+	err := retryWithBackoff(ctx, "create span exporter", cfg.ExporterInitAttempts, cfg.ExporterInitBackoff, func() error {
+		var err error
+		exp, err = newSpanExporter(ctx, cfg)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create span exporter: %w", err)
+	}
+	logSpanExporter(ctx, exp, cfg)
+
+	exp = monitoredExporter{SpanExporter: exp, health: health}
+	return newTracerProvider(res, cfg, base, sdktrace.WithSpanProcessor(newQueueMonitor(exp, cfg, health)))
+}
+
+// newTracerProvider builds a tracer provider with the given span processing
+// options and a sampler deferring to base, which applies the per-route
+// sampling rules, skips the untraced routes and honours X-Force-Trace, and
+// installs it, together with the configured propagators, as the global
+// default.
+func newTracerProvider(res *resource.Resource, cfg Config, base sdktrace.Sampler, opts ...sdktrace.TracerProviderOption) (*sdktrace.TracerProvider, error) {
+	routed := newRouteRatioSampler(base, cfg.SamplingRules)
+	sampler := forceTraceSampler{base: newRouteFilterSampler(routed, cfg.UntracedRoutes)}
+	slog.Info("trace sampler configured", "sampler", sampler.Description())
+
+	propagator, names, err := parsePropagators(cfg.Propagators)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("trace propagators configured", "propagators", names)
+
+	tp := sdktrace.NewTracerProvider(append(opts,
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sampler),
+	)...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+	return tp, nil
+}
+
+// disableTracing installs a no-op tracer provider, so that spans cost next to
+// nothing while the configured propagators still pass trace context through.
+// It gives the baseline to measure the overhead of tracing against.
+func disableTracing(cfg Config) error {
+	propagator, names, err := parsePropagators(cfg.Propagators)
+	if err != nil {
+		return err
+	}
+	slog.Info("trace propagators configured", "propagators", names)
+
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	otel.SetTextMapPropagator(propagator)
+	return nil
+}
+
+// setupMetrics creates the metric reader selected by the environment and
+// installs a meter provider reading from it.
+func setupMetrics(ctx context.Context, res *resource.Resource) (*sdkmetric.MeterProvider, error) {
+	reader, err := autoexport.NewMetricReader(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create metric reader: %w", err)
+	}
+
+	mp := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+	return mp, nil
+}
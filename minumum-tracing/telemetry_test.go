@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewTracerProviderExportsEchoSpan(t *testing.T) {
+	cfg := testConfig(t)
+	exp := tracetest.NewInMemoryExporter()
+	tp, err := newTracerProvider(resource.Empty(), cfg, sdktrace.AlwaysSample(), sdktrace.WithSyncer(exp))
+	if err != nil {
+		t.Fatalf("newTracerProvider() error = %v", err)
+	}
+	defer tp.Shutdown(context.Background())
+	if otel.GetTracerProvider() != trace.TracerProvider(tp) {
+		t.Error("tracer provider not installed as the global default")
+	}
+	s, err := newServer(cfg, new(slog.LevelVar), nil, nil)
+	if err != nil {
+		t.Fatalf("newServer() error = %v", err)
+	}
+
+	serve(s, httptest.NewRequest("GET", "/echo/hi", nil))
+
+	span := findSpan(t, exp, "echo-handler")
+	if span.SpanKind != trace.SpanKindServer {
+		t.Errorf("span kind = %v, want server", span.SpanKind)
+	}
+	if v, _ := spanAttr(span, "http.route"); v.AsString() != "/echo/{message}" {
+		t.Errorf("http.route = %q, want /echo/{message}", v.AsString())
+	}
+}
+
+func TestDisableTracing(t *testing.T) {
+	cfg := testConfig(t)
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	if err := disableTracing(cfg); err != nil {
+		t.Fatalf("disableTracing() error = %v", err)
+	}
+	s, err := newServer(cfg, new(slog.LevelVar), nil, nil)
+	if err != nil {
+		t.Fatalf("newServer() error = %v", err)
+	}
+
+	for _, target := range []string{"/echo/hi", "/echo/hi/stream", "/echo?message=hi"} {
+		if w := serve(s, httptest.NewRequest("GET", target, nil)); w.Code != http.StatusOK {
+			t.Errorf("GET %s status = %d, want %d", target, w.Code, http.StatusOK)
+		}
+	}
+}
+
+// benchmarkEcho measures the throughput of GET /echo/{message} on s, with the
+// logs discarded.
+func benchmarkEcho(b *testing.B, s http.Handler) {
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(io.Discard, nil)))
+	b.Cleanup(func() { slog.SetDefault(logger) })
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/echo/hi", nil))
+		}
+	})
+}
+
+func BenchmarkEchoTraced(b *testing.B) {
+	cfg := testConfig(b)
+	tp, err := newTracerProvider(resource.Empty(), cfg, sdktrace.AlwaysSample(), sdktrace.WithBatcher(tracetest.NewNoopExporter()))
+	if err != nil {
+		b.Fatalf("newTracerProvider() error = %v", err)
+	}
+	b.Cleanup(func() { tp.Shutdown(context.Background()) })
+	s, err := newServer(cfg, new(slog.LevelVar), nil, nil)
+	if err != nil {
+		b.Fatalf("newServer() error = %v", err)
+	}
+	benchmarkEcho(b, s)
+}
+
+func BenchmarkEchoUntraced(b *testing.B) {
+	cfg := testConfig(b)
+	previous := otel.GetTracerProvider()
+	b.Cleanup(func() { otel.SetTracerProvider(previous) })
+	if err := disableTracing(cfg); err != nil {
+		b.Fatalf("disableTracing() error = %v", err)
+	}
+	s, err := newServer(cfg, new(slog.LevelVar), nil, nil)
+	if err != nil {
+		b.Fatalf("newServer() error = %v", err)
+	}
+	benchmarkEcho(b, s)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tenantHeader = "X-Tenant-Id"
+	// tenantBaggageKey is the baggage member carrying the tenant to
+	// downstream services.
+	tenantBaggageKey = "tenant.id"
+)
+
+// tenantIDPattern keeps tenant IDs short and safe to use as a baggage value
+// and a log field.
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+type tenantKey struct{}
+
+// tenantFromContext returns the tenant ID stored by the tenant middleware.
+func tenantFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(tenantKey{}).(string)
+	return id
+}
+
+// tenant reads the caller's X-Tenant-Id, answering 400 when it is malformed
+// or, if allowed is not empty, not one of the allowed tenants. A valid tenant
+// is added to the baggage, so outgoing requests carry it, and recorded on the
+// active span and in the logs. Requests without the header pass through.
+func tenant(allowed []string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(tenantHeader)
+			if id == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			span := trace.SpanFromContext(ctx)
+			if !tenantIDPattern.MatchString(id) || (len(allowed) > 0 && !slices.Contains(allowed, id)) {
+				span.AddEvent("invalid_tenant")
+				writeJSONError(w, http.StatusBadRequest, "invalid "+tenantHeader, traceIDFromContext(ctx))
+				return
+			}
+
+			if bag, err := withBaggageMember(baggage.FromContext(ctx), tenantBaggageKey, id); err != nil {
+				slog.WarnContext(ctx, "failed to add tenant to baggage", "error", err)
+			} else {
+				ctx = baggage.ContextWithBaggage(ctx, bag)
+			}
+			span.SetAttributes(attribute.String("tenant.id", id))
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, tenantKey{}, id)))
+		})
+	}
+}
+
+// withBaggageMember returns bag with the member key set to value.
+func withBaggageMember(bag baggage.Baggage, key, value string) (baggage.Baggage, error) {
+	member, err := baggage.NewMember(key, value)
+	if err != nil {
+		return bag, err
+	}
+	return bag.SetMember(member)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/baggage"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTenant(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{"valid", "acme", http.StatusOK, "acme"},
+		{"missing", "", http.StatusOK, ""},
+		{"malformed", "acme corp", http.StatusBadRequest, ""},
+		{"not allowed", "globex", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exp))
+			defer tp.Shutdown(context.Background())
+			logs := captureLogs(t)
+			var gotCtx context.Context
+			h := tenant([]string{"acme"})(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				gotCtx = r.Context()
+				slog.InfoContext(gotCtx, "handled")
+			}))
+
+			ctx, span := tp.Tracer("test").Start(context.Background(), "request")
+			r := httptest.NewRequest("GET", "/echo/hi", nil).WithContext(ctx)
+			if tt.header != "" {
+				r.Header.Set(tenantHeader, tt.header)
+			}
+			w := serve(h, r)
+			span.End()
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			v, _ := spanAttr(findSpan(t, exp, "request"), "tenant.id")
+			if v.AsString() != tt.wantTenant {
+				t.Errorf("tenant.id attribute = %q, want %q", v.AsString(), tt.wantTenant)
+			}
+			if gotCtx == nil {
+				return
+			}
+			if got := tenantFromContext(gotCtx); got != tt.wantTenant {
+				t.Errorf("tenantFromContext() = %q, want %q", got, tt.wantTenant)
+			}
+			if got := baggage.FromContext(gotCtx).Member(tenantBaggageKey).Value(); got != tt.wantTenant {
+				t.Errorf("baggage %s = %q, want %q", tenantBaggageKey, got, tt.wantTenant)
+			}
+			if got, _ := findLog(t, logs(), "handled")["tenant_id"].(string); got != tt.wantTenant {
+				t.Errorf("tenant_id log field = %q, want %q", got, tt.wantTenant)
+			}
+		})
+	}
+}
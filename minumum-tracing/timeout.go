@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// timeout bounds handler execution to d. When the deadline passes first,
+// a "timeout" event is added to the active span and, unless the handler has
+// already flushed part of its response, the client receives a clean 503 JSON
+// error. A zero d disables the timeout.
+func timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		if d <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			serveWithin(ctx, w, r, next, func(started bool) {
+				trace.SpanFromContext(ctx).AddEvent("timeout", trace.WithAttributes(
+					attribute.String("timeout", d.String()),
+					attribute.Bool("response.started", started),
+				))
+				if !started {
+					writeJSONError(w, http.StatusServiceUnavailable, "request timed out", traceIDFromContext(ctx))
+				}
+			})
+		})
+	}
+}
+
+// serveWithin runs next with ctx and buffers its output, so that if ctx is
+// done before next returns, expired can write a clean error response
+// instead. A handler that flushes commits its response at that point and
+// streams the rest; if ctx is done afterwards, expired is told the response
+// has started and the handler's further writes fail. Handler panics are
+// propagated to the caller's goroutine.
+func serveWithin(ctx context.Context, w http.ResponseWriter, r *http.Request, next http.Handler, expired func(started bool)) {
+	tw := &timeoutWriter{w: w, header: make(http.Header)}
+	done := make(chan struct{})
+	panicChan := make(chan any, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		next.ServeHTTP(tw, r.WithContext(ctx))
+		close(done)
+	}()
+
+	select {
+	case p := <-panicChan:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		tw.finish()
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		tw.timedOut = true
+		expired(tw.committed)
+	}
+}
+
+// timeoutWriter buffers a handler response until it is known whether the
+// handler finished before the deadline, or until the handler flushes.
+type timeoutWriter struct {
+	mu       sync.Mutex
+	w        http.ResponseWriter
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+	// committed is set once the response has been written to w.
+	committed bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.committed {
+		return tw.w.Write(p)
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// FlushError commits the response and flushes it to the client.
+func (tw *timeoutWriter) FlushError() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return http.ErrHandlerTimeout
+	}
+	if !tw.committed {
+		tw.commit()
+	}
+	return http.NewResponseController(tw.w).Flush()
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.FlushError()
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// commit writes the status, the headers and the body buffered so far to w.
+// Trailer values are held back: set before the status is written, they would
+// be sent as ordinary headers.
+func (tw *timeoutWriter) commit() {
+	trailers := declaredTrailers(tw.header)
+	dst := tw.w.Header()
+	for k, vv := range tw.header {
+		if !trailers[k] && !strings.HasPrefix(k, http.TrailerPrefix) {
+			dst[k] = vv
+		}
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	tw.w.WriteHeader(tw.code)
+	tw.w.Write(tw.buf.Bytes())
+	tw.buf.Reset()
+	tw.committed = true
+}
+
+// finish completes the response of a handler that returned in time, sending
+// the trailers it set after the body.
+func (tw *timeoutWriter) finish() {
+	if !tw.committed {
+		tw.commit()
+	}
+	trailers := declaredTrailers(tw.header)
+	dst := tw.w.Header()
+	for k, vv := range tw.header {
+		if trailers[k] || strings.HasPrefix(k, http.TrailerPrefix) {
+			dst[k] = vv
+		}
+	}
+}
+
+// declaredTrailers returns the canonical keys announced in the Trailer
+// header of h.
+func declaredTrailers(h http.Header) map[string]bool {
+	trailers := make(map[string]bool)
+	for _, v := range h.Values("Trailer") {
+		for _, k := range strings.Split(v, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				trailers[http.CanonicalHeaderKey(k)] = true
+			}
+		}
+	}
+	return trailers
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// blockUntilDone returns a handler that writes prefix, flushing it when it is
+// not empty, then waits for the request context to end.
+func blockUntilDone(prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if prefix != "" {
+			w.Write([]byte(prefix))
+			http.NewResponseController(w).Flush()
+		}
+		<-r.Context().Done()
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	h := chain(blockUntilDone(""), tracing(s.tracer, "slow"), timeout(20*time.Millisecond))
+
+	w := serve(h, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+	var body ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body.Error != "request timed out" {
+		t.Errorf("body = %+v (%v), want the timeout error", body, err)
+	}
+	span := findSpan(t, exp, "slow")
+	if len(span.Events) != 1 || span.Events[0].Name != "timeout" {
+		t.Errorf("span events = %+v, want a timeout event", span.Events)
+	}
+}
+
+func TestTimeoutAfterFlush(t *testing.T) {
+	s, exp := newTestServer(t, testConfig(t))
+	h := chain(blockUntilDone("partial"), tracing(s.tracer, "slow"), timeout(20*time.Millisecond))
+
+	w := serve(h, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("response = %d %q, want the flushed part only", w.Code, w.Body.String())
+	}
+	span := findSpan(t, exp, "slow")
+	if len(span.Events) != 1 || span.Events[0].Name != "timeout" {
+		t.Fatalf("span events = %+v, want a timeout event", span.Events)
+	}
+	attrs := attribute.NewSet(span.Events[0].Attributes...)
+	if v, _ := attrs.Value("response.started"); !v.AsBool() {
+		t.Errorf("timeout event attributes = %v, want response.started", span.Events[0].Attributes)
+	}
+}
+
+func TestTimeoutStreaming(t *testing.T) {
+	t.Setenv("REQUEST_TIMEOUT", "5s")
+	s, exp := newTestServer(t, testConfig(t))
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/echo/abc/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(body) != "abc" {
+		t.Errorf("body = %q, want abc", body)
+	}
+	if resp.Header.Get(processingTimeTrailer) != "" {
+		t.Errorf("%s sent as a header", processingTimeTrailer)
+	}
+	if resp.Trailer.Get(processingTimeTrailer) == "" {
+		t.Errorf("trailers = %v, want %s", resp.Trailer, processingTimeTrailer)
+	}
+	span := findSpan(t, exp, "echo-stream")
+	if v, _ := spanAttr(span, "stream.flushed"); !v.AsBool() {
+		t.Error("stream was not flushed under REQUEST_TIMEOUT")
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// defaultMaxMessageAttrLength is how many characters of a message are
+// recorded on spans unless SPAN_MESSAGE_MAX_LENGTH says otherwise.
+const defaultMaxMessageAttrLength = 256
+
+// messageAttributes returns the message span attribute, cut to
+// maxMessageAttrLength characters with a "..." suffix and flagged with
+// message.truncated, so that long messages do not bloat trace storage. The
+// response still carries the full message.
+func (s *server) messageAttributes(message string) []attribute.KeyValue {
+	if utf8.RuneCountInString(message) <= s.maxMessageAttrLength {
+		return []attribute.KeyValue{attribute.String("message", message)}
+	}
+	cut, n := 0, 0
+	for i := range message {
+		if n == s.maxMessageAttrLength {
+			cut = i
+			break
+		}
+		n++
+	}
+	return []attribute.KeyValue{
+		attribute.String("message", message[:cut]+"..."),
+		attribute.Bool("message.truncated", true),
+	}
+}
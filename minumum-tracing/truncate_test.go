@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMessageAttributeTruncation(t *testing.T) {
+	t.Setenv("SPAN_MESSAGE_MAX_LENGTH", "5")
+	tests := []struct {
+		message       string
+		wantAttr      string
+		wantTruncated bool
+	}{
+		{"hello", "hello", false},
+		{"hello world", "hello...", true},
+		{"こんにちは世界", "こんにちは...", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			s, exp := newTestServer(t, testConfig(t))
+
+			w := serve(s, httptest.NewRequest("GET", "/echo/"+url.PathEscape(tt.message), nil))
+
+			var resp Response
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Message != tt.message {
+				t.Errorf("response = %+v (%v), want the full message", resp, err)
+			}
+			span := findSpan(t, exp, "echo-handler")
+			if v, _ := spanAttr(span, "message"); v.AsString() != tt.wantAttr {
+				t.Errorf("message attribute = %q, want %q", v.AsString(), tt.wantAttr)
+			}
+			if v, _ := spanAttr(span, "message.truncated"); v.AsBool() != tt.wantTruncated {
+				t.Errorf("message.truncated = %t, want %t", v.AsBool(), tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestMessageAttributeDefaultLimit(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+
+	attrs := s.messageAttributes(strings.Repeat("a", defaultMaxMessageAttrLength+1))
+
+	if got := attrs[0].Value.AsString(); got != strings.Repeat("a", defaultMaxMessageAttrLength)+"..." {
+		t.Errorf("message attribute has %d characters, want %d and ...", len(got), defaultMaxMessageAttrLength)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// registerUptime reports the seconds elapsed since start as an asynchronous
+// gauge, observed whenever the meter provider collects metrics.
+func registerUptime(meter metric.Meter, start time.Time) error {
+	_, err := meter.Float64ObservableGauge("process.uptime",
+		metric.WithDescription("Seconds since the process started."),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			o.Observe(time.Since(start).Seconds())
+			return nil
+		}),
+	)
+	return err
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestUptimeGauge(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	start := time.Now()
+	if err := registerUptime(mp.Meter("test"), start); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	if len(rm.ScopeMetrics) != 1 || len(rm.ScopeMetrics[0].Metrics) != 1 {
+		t.Fatalf("collected %+v, want the process.uptime gauge", rm.ScopeMetrics)
+	}
+	m := rm.ScopeMetrics[0].Metrics[0]
+	points := m.Data.(metricdata.Gauge[float64]).DataPoints
+	if m.Name != "process.uptime" || len(points) != 1 {
+		t.Fatalf("metric %s has %d points, want one process.uptime point", m.Name, len(points))
+	}
+	if got := points[0].Value; got < 0.01 || got > time.Since(start).Seconds() {
+		t.Errorf("process.uptime = %v, want the seconds since start", got)
+	}
+}
+
+func TestVersionUptime(t *testing.T) {
+	s, _ := newTestServer(t, testConfig(t))
+	time.Sleep(10 * time.Millisecond)
+
+	w := serve(s, httptest.NewRequest("GET", "/version", nil))
+
+	var resp VersionResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if resp.UptimeSeconds < 0.01 {
+		t.Errorf("uptime_seconds = %v, want at least 0.01", resp.UptimeSeconds)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X main.version=... -X main.gitCommit=... -X main.buildTime=...".
+var (
+	version   = "dev"
+	gitCommit = "dev"
+	buildTime = "dev"
+)
+
+type VersionResponse struct {
+	Version       string  `json:"version"`
+	GitCommit     string  `json:"git_commit"`
+	BuildTime     string  `json:"build_time"`
+	GoVersion     string  `json:"go_version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+func (s *server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	resp := VersionResponse{
+		Version:       serviceVersion(),
+		GitCommit:     gitCommit,
+		BuildTime:     buildTime,
+		GoVersion:     runtime.Version(),
+		UptimeSeconds: time.Since(s.startTime).Seconds(),
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("build.version", resp.Version),
+		attribute.String("build.git_commit", resp.GitCommit),
+		attribute.String("build.time", resp.BuildTime),
+		attribute.String("build.go_version", resp.GoVersion),
+	)
+
+	s.writeJSON(ctx, w, resp)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// warmupPath is where App Engine sends warmup requests to new instances when
+// the warmup inbound service is enabled in app.yaml.
+const warmupPath = "/_ah/warmup"
+
+// handleWarmup prepares a new instance before it receives traffic: it opens
+// a connection to OUTBOUND_URL, when set, and flushes the spans recorded so
+// far so that the exporter connects. Failures are recorded on the span but
+// still answer 200, since the instance can serve without them.
+func (s *server) handleWarmup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	if s.outboundURL != "" {
+		err := s.primeOutbound(ctx)
+		if err != nil {
+			span.RecordError(err)
+			slog.WarnContext(ctx, "warmup could not reach the outbound URL", "url", s.outboundURL, "error", err)
+		}
+		span.SetAttributes(attribute.Bool("warmup.outbound_ready", err == nil))
+	}
+
+	if tp, ok := otel.GetTracerProvider().(interface{ ForceFlush(context.Context) error }); ok {
+		err := tp.ForceFlush(ctx)
+		if err != nil {
+			span.RecordError(err)
+			slog.WarnContext(ctx, "warmup could not flush spans", "error", err)
+		}
+		span.SetAttributes(attribute.Bool("warmup.export_ready", err == nil))
+	}
+
+	slog.InfoContext(ctx, "instance warmed up")
+	w.WriteHeader(http.StatusOK)
+}
+
+// primeOutbound sends a HEAD request to OUTBOUND_URL so that the connection
+// is pooled before the first request needs it.
+func (s *server) primeOutbound(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.outboundURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.outboundClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
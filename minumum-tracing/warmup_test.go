@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestWarmup(t *testing.T) {
+	var method string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+	}))
+	defer upstream.Close()
+	t.Setenv("OUTBOUND_URL", upstream.URL)
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("GET", warmupPath, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if method != http.MethodHead {
+		t.Errorf("outbound URL got %q, want a HEAD request priming the connection", method)
+	}
+	span := findSpan(t, exp, "warmup")
+	for _, key := range []string{"warmup.outbound_ready", "warmup.export_ready"} {
+		if v, _ := spanAttr(span, attribute.Key(key)); !v.AsBool() {
+			t.Errorf("%s = false, want true", key)
+		}
+	}
+}
+
+func TestWarmupOutboundUnreachable(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	upstream.Close()
+	t.Setenv("OUTBOUND_URL", upstream.URL)
+	s, exp := newTestServer(t, testConfig(t))
+
+	w := serve(s, httptest.NewRequest("GET", warmupPath, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d even without the outbound URL", w.Code, http.StatusOK)
+	}
+	if v, _ := spanAttr(findSpan(t, exp, "warmup"), "warmup.outbound_ready"); v.AsBool() {
+		t.Error("warmup.outbound_ready = true, want false for an unreachable URL")
+	}
+}
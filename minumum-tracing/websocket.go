@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// upgrader keeps the default same-origin check, so browsers on other sites
+// cannot open connections on behalf of their users. Failed upgrades get the
+// usual JSON error body.
+var upgrader = websocket.Upgrader{
+	Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		writeJSONError(w, status, reason.Error(), traceIDFromContext(r.Context()))
+	},
+}
+
+// handleEchoWS upgrades to a WebSocket and echoes every message back. The
+// connection span lives as long as the connection, and each message gets a
+// child span. Messages longer than maxMessageLength close the connection.
+func (s *server) handleEchoWS(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error.
+		span.RecordError(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(int64(s.maxMessageLength))
+	span.AddEvent("connection upgraded")
+
+	var messages int
+	defer func() {
+		span.SetAttributes(attribute.Int("ws.messages", messages))
+	}()
+	for {
+		typ, data, err := conn.ReadMessage()
+		if err != nil {
+			switch {
+			case websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway):
+				span.AddEvent("connection closed")
+			case errors.Is(err, websocket.ErrReadLimit):
+				span.AddEvent("message too long", trace.WithAttributes(
+					attribute.Int("ws.max_message_length", s.maxMessageLength),
+				))
+			default:
+				span.RecordError(err)
+				slog.WarnContext(ctx, "websocket connection failed", "error", err)
+			}
+			return
+		}
+		messages++
+		if err := s.echoWSMessage(ctx, conn, typ, data); err != nil {
+			return
+		}
+	}
+}
+
+// echoWSMessage writes one received message back inside its own span.
+func (s *server) echoWSMessage(ctx context.Context, conn *websocket.Conn, typ int, data []byte) error {
+	ctx, span := s.tracer.Start(ctx, "echo-ws-message", trace.WithAttributes(
+		attribute.Int("ws.message.type", typ),
+		attribute.Int("ws.message.size", len(data)),
+	))
+	defer span.End()
+	s.echoCount.Add(ctx, 1)
+
+	if err := conn.WriteMessage(typ, data); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "write failed")
+		return err
+	}
+	return nil
+}